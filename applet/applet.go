@@ -3,6 +3,7 @@ package applet
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/octago/sflags/gen/gpflag"
 	"github.com/sethpollack/dockerbox/dockerbox"
@@ -11,13 +12,15 @@ import (
 	"golang.org/x/term"
 )
 
-const dockerExe = "docker"
-
 type Root struct {
+	Runtime  string             `json:"runtime"`
+	Scope    string             `json:"scope"`
 	Ignore   map[string]Applet  `json:"ignore"`
 	Applets  Applets            `json:"applets"`
 	Volumes  map[string]Volume  `json:"volumes"`
 	Networks map[string]Network `json:"networks"`
+	// Locked pins image references to digests, see the lock package.
+	Locked map[string]string `json:"-"`
 }
 
 type Applets map[string]Applet
@@ -25,44 +28,80 @@ type Applets map[string]Applet
 type Applet struct {
 	AppletName string `json:"applet_name" desc:"name of the applet"`
 
-	Entrypoint string `json:"entrypoint" flag:"entrypoint" desc:"Overwrite the default ENTRYPOINT of the image"`
-	Hostname   string `json:"hostname" flag:"hostname" desc:"Container host name"`
-	Image      string `json:"image" flag:"image" desc:"Container image"`
-	Name       string `json:"name" flag:"name" desc:"Assign a name to the container"`
-	Restart    string `json:"restart" flag:"restart" desc:"Restart policy to apply when a container exits (default \no\")"`
-	Tag        string `json:"image_tag" flag:"tag" desc:"Container image tag"`
-	WorkDir    string `json:"work_dir" flag:"workdir w" desc:"Working directory inside the container"`
-
-	Detach      bool `json:"detach" flag:"detach d" desc:"Run container in background and print container ID"`
-	Interactive bool `json:"interactive" flag:"interactive i" desc:"Keep STDIN open even if not attached"`
-	Kill        bool `json:"kill" flag:"kill" desc:"Kill previous run on container with same name"`
-	Privileged  bool `json:"privileged" flag:"privileged" desc:"Give extended privileges to this container"`
-	Pull        bool `json:"pull" flag:"pull" desc:"Pull image before running it"`
-	RM          bool `json:"rm" flag:"rm" desc:"Automatically remove the container when it exits"`
-	TTY         bool `json:"tty" flag:"tty t" desc:"Allocate a pseudo-TTY"`
+	CPUs        string `json:"cpus" flag:"cpus" desc:"Number of CPUs"`
+	Entrypoint  string `json:"entrypoint" flag:"entrypoint" desc:"Overwrite the default ENTRYPOINT of the image"`
+	EnvFilter   string `json:"env_filter" flag:"env-filter" desc:"Filter env vars passed to container from --all-envs, as a glob or a /regexp/"`
+	Hostname    string `json:"hostname" flag:"hostname" desc:"Container host name"`
+	IdleTimeout string `json:"idle_timeout" flag:"idle-timeout" desc:"Remove a persistent container after it has been idle this long (default 1h)"`
+	Image       string `json:"image" flag:"image" desc:"Container image"`
+	Memory      string `json:"memory" flag:"memory m" desc:"Memory limit"`
+	MemorySwap  string `json:"memory_swap" flag:"memory-swap" desc:"Swap limit equal to memory plus swap: '-1' to enable unlimited swap"`
+	Name        string `json:"name" flag:"name" desc:"Assign a name to the container"`
+	NetworkMode string `json:"network_mode" flag:"network-mode" desc:"Connect the container to a network mode, e.g. none or host"`
+	PullPolicy  string `json:"pull_policy" flag:"pull-policy" desc:"Pull image missing, always, never, daily or after a duration such as 12h"`
+	Restart     string `json:"restart" flag:"restart" desc:"Restart policy to apply when a container exits (default \no\")"`
+	Runtime     string `json:"runtime" flag:"runtime" desc:"Container runtime client (docker, podman, nerdctl or a path to a client binary)"`
+	Scope       string `json:"scope" flag:"scope" desc:"Scope of the container name, project or global (default \"global\")"`
+	ShmSize     string `json:"shm_size" flag:"shm-size" desc:"Size of /dev/shm"`
+	Tag         string `json:"image_tag" flag:"tag" desc:"Container image tag"`
+	User        string `json:"user" flag:"user u" desc:"Username or UID (format: <name|uid>[:<group|gid>])"`
+	WorkDir     string `json:"work_dir" flag:"workdir w" desc:"Working directory inside the container"`
+
+	AllEnvs         bool `json:"all_envs" flag:"all-envs" desc:"Pass all envars to container"`
+	Detach          bool `json:"detach" flag:"detach d" desc:"Run container in background and print container ID"`
+	Hardened        bool `json:"hardened" flag:"hardened" desc:"Drop all capabilities, disallow new privileges and mount the root filesystem read only"`
+	HostGroups      bool `json:"host_groups" flag:"host-groups" desc:"Add the invoking user's supplementary groups (with host-user)"`
+	HostUser        bool `json:"host_user" flag:"host-user" desc:"Run as the invoking user's uid and gid"`
+	Interactive     bool `json:"interactive" flag:"interactive i" desc:"Keep STDIN open even if not attached"`
+	Inverse         bool `json:"inverse" flag:"inverse" desc:"Inverse env-filter"`
+	Kill            bool `json:"kill" flag:"kill" desc:"Kill previous run on container with same name"`
+	NoNewPrivileges bool `json:"no_new_privileges" flag:"no-new-privileges" desc:"Disallow processes from gaining new privileges"`
+	Persistent      bool `json:"persistent" flag:"persistent" desc:"Run in a long-lived container reused with exec"`
+	Privileged      bool `json:"privileged" flag:"privileged" desc:"Give extended privileges to this container"`
+	Pull            bool `json:"pull" flag:"pull" desc:"Pull image before running it"`
+	ReadOnly        bool `json:"read_only" flag:"read-only" desc:"Mount the container's root filesystem as read only"`
+	RewriteOutput   bool `json:"rewrite_output" flag:"rewrite-output" desc:"Rewrite container paths inside bind mounts in the output back to host paths"`
+	RM              bool `json:"rm" flag:"rm" desc:"Automatically remove the container when it exits"`
+	TranslatePaths  bool `json:"translate_paths" flag:"translate-paths" desc:"Rewrite arguments naming host paths inside bind mounts to their container paths"`
+	TTY             bool `json:"tty" flag:"tty t" desc:"Allocate a pseudo-TTY"`
+
+	CPUShares int64 `json:"cpu_shares" flag:"cpu-shares c" desc:"CPU shares (relative weight)"`
+	PidsLimit int64 `json:"pids_limit" flag:"pids-limit" desc:"Tune container pids limit (set -1 for unlimited)"`
 
 	AfterHooks  []Applet `json:"after_hooks" flag:"after-hook" desc:"Run container after"`
 	BeforeHooks []Applet `json:"before_hooks" flag:"before-hook" desc:"Run container before."`
+	CapAdd      []string `json:"cap_add" flag:"cap-add" desc:"Add Linux capabilities"`
+	CapDrop     []string `json:"cap_drop" flag:"cap-drop" desc:"Drop Linux capabilities"`
 	Command     []string `json:"command" flag:"command" desc:"Command to run in container"`
 	DNS         []string `json:"dns" flag:"dns" desc:"Set custom DNS servers"`
 	DNSOption   []string `json:"dns_option" flag:"dns-option" desc:"Set DNS options"`
 	DNSSearch   []string `json:"dns_search" flag:"dns-search" desc:"Set custom DNS search domains"`
 	Env         []string `json:"environment" flag:"environment e" desc:"Set environment variables"`
 	EnvFile     []string `json:"env_file" flag:"env-file" desc:"Read in a file of environment variables"`
+	GroupAdd    []string `json:"group_add" flag:"group-add" desc:"Add additional groups to join"`
+	Labels      []string `json:"labels" flag:"label l" desc:"Set meta data on a container"`
 	Links       []string `json:"links" flag:"link" desc:"Add link to another container"`
 	Ports       []string `json:"ports" flag:"publish p" desc:"Publish a container's port(s) to the host"`
 	Networks    []string `json:"networks" flag:"network" desc:"Connect a container to a network"`
+	SecurityOpt []string `json:"security_opt" flag:"security-opt" desc:"Security Options"`
+	Tmpfs       []string `json:"tmpfs" flag:"tmpfs" desc:"Mount a tmpfs directory"`
 	Volumes     []string `json:"volumes" flag:"volume v" desc:"Bind mount a volume"`
+
+	Ulimits Ulimits `json:"ulimits" flag:"ulimit" desc:"Ulimit options"`
+
+	Build *Build `json:"build" flag:"-"`
 }
 
 type Volume struct {
 	Name   string `json:"name"`
 	Driver string `json:"driver"`
+	Scope  string `json:"scope"`
 }
 
 type Network struct {
 	Name   string `json:"name"`
 	Driver string `json:"driver"`
+	Scope  string `json:"scope"`
 }
 
 func (root *Root) Compile(cfg *dockerbox.Config) ([]runner.Cmd, error) {
@@ -78,30 +117,29 @@ func (root *Root) Compile(cfg *dockerbox.Config) ([]runner.Cmd, error) {
 		return nil, fmt.Errorf("failed to create flag set from applet: %v", err)
 	}
 
-	dArgs, aArgs := splitArgs(cfg.Separator, cfg.Args)
+	dArgs, aArgs := cfg.SplitArgs()
 	err = fSet.Parse(dArgs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse applet flags: %v", err)
 	}
 
-	err = root.validate(a)
+	err = root.validate(cfg, a)
 	if err != nil {
 		return nil, fmt.Errorf("failed to validate applet: %v", err)
 	}
 
-	allCmds := []runner.Cmd{}
-
-	for _, n := range root.Networks {
-		allCmds = append(allCmds, n.createNetworkCmd())
+	if a.TranslatePaths {
+		aArgs = a.translateArgs(aArgs)
 	}
 
-	for _, v := range a.Volumes {
-		if vol, ok := root.Volumes[v]; ok {
-			allCmds = append(allCmds, vol.createVolumeCmd())
-		}
+	scoped, a, err := root.scoped(cfg, a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve names: %v", err)
 	}
 
-	appletCmds, err := root.Applets.allCmds(a, aArgs...)
+	allCmds := scoped.resourceCmds(cfg, a)
+
+	appletCmds, err := scoped.allCmds(cfg, a, aArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get applet commands: %v", err)
 	}
@@ -111,7 +149,7 @@ func (root *Root) Compile(cfg *dockerbox.Config) ([]runner.Cmd, error) {
 	return allCmds, nil
 }
 
-func (root *Root) validate(a Applet) error {
+func (root *Root) validate(cfg *dockerbox.Config, a Applet) error {
 	err := a.validateRequired()
 	if err != nil {
 		return err
@@ -122,12 +160,117 @@ func (root *Root) validate(a Applet) error {
 		return err
 	}
 
+	err = root.validateRuntimes(cfg, a)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validatePersistent)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validateLimits)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validateSecurity)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validateUser)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validateEnv)
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, Applet.validatePull)
+	if err != nil {
+		return err
+	}
+
+	err = root.validateScope(a)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, error) {
-	var allCmds func(Applet, ...string) ([]runner.Cmd, error)
-	allCmds = func(applet Applet, args ...string) ([]runner.Cmd, error) {
+// runtime resolves the runtime of an applet. The applet's own setting wins
+// over DOCKERBOX_RUNTIME, which wins over the root configuration.
+func (root *Root) runtime(cfg *dockerbox.Config, a Applet) runtime {
+	for _, exe := range []string{a.Runtime, cfg.Runtime, root.Runtime} {
+		if exe != "" {
+			return newRuntime(exe)
+		}
+	}
+
+	return newRuntime(defaultRuntime)
+}
+
+// eachApplet calls fn for a and every hook it runs. Hooks are compiled
+// into the same commands as the applet, so they need the same validation.
+// validateHooks has to pass first, so the hooks exist and aren't circular.
+func (root *Root) eachApplet(a Applet, fn func(Applet) error) error {
+	err := fn(a)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range append(a.BeforeHooks, a.AfterHooks...) {
+		err := root.eachApplet(root.Applets[h.AppletName], fn)
+		if err != nil {
+			return fmt.Errorf("hook %s: %v", h.AppletName, err)
+		}
+	}
+
+	return nil
+}
+
+func (root *Root) validateRuntimes(cfg *dockerbox.Config, a Applet) error {
+	var validate func(Applet) error
+	validate = func(applet Applet) error {
+		rt := root.runtime(cfg, applet)
+
+		err := rt.validateApplet(applet)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range applet.Volumes {
+			if vol, ok := root.volume(v); ok {
+				err := rt.validateVolume(vol)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, h := range append(applet.BeforeHooks, applet.AfterHooks...) {
+			err := validate(root.Applets[h.AppletName])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return validate(a)
+}
+
+func (root *Root) allCmds(cfg *dockerbox.Config, current Applet, args ...string) ([]runner.Cmd, error) {
+	applets := root.Applets
+
+	var allCmds func(Applet, string, ...string) ([]runner.Cmd, error)
+	allCmds = func(applet Applet, source string, args ...string) ([]runner.Cmd, error) {
 		cmds := []runner.Cmd{}
 
 		for _, bh := range applet.BeforeHooks {
@@ -136,7 +279,7 @@ func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, er
 				return nil, fmt.Errorf("before hook %s not found", bh.AppletName)
 			}
 
-			cmd, err := allCmds(h)
+			cmd, err := allCmds(h, "before hook")
 			if err != nil {
 				return cmds, err
 			}
@@ -144,10 +287,25 @@ func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, er
 			cmds = append(cmds, cmd...)
 		}
 
-		cmds = append(
-			cmds,
-			applet.appletCmds(args...)...,
-		)
+		if applet.Build != nil {
+			var err error
+			applet, err = applet.withBuiltImage(cfg)
+			if err != nil {
+				return cmds, err
+			}
+		}
+
+		applet, err := applet.withLockedImage(root.Locked)
+		if err != nil {
+			return cmds, err
+		}
+
+		cmd, err := applet.appletCmds(cfg, root.runtime(cfg, applet), source, args...)
+		if err != nil {
+			return cmds, err
+		}
+
+		cmds = append(cmds, cmd...)
 
 		for _, ah := range applet.AfterHooks {
 			h, ok := applets[ah.AppletName]
@@ -155,7 +313,7 @@ func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, er
 				return cmds, fmt.Errorf("after hook %s not found", ah.AppletName)
 			}
 
-			cmd, err := allCmds(h)
+			cmd, err := allCmds(h, "after hook")
 			if err != nil {
 				return cmds, err
 			}
@@ -166,7 +324,7 @@ func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, er
 		return cmds, nil
 	}
 
-	cmds, err := allCmds(current, args...)
+	cmds, err := allCmds(current, "applet", args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commands: %v", err)
 	}
@@ -174,37 +332,33 @@ func (applets Applets) allCmds(current Applet, args ...string) ([]runner.Cmd, er
 	return cmds, nil
 }
 
-func (a Applet) killCmd() runner.Cmd {
+func (a Applet) killCmd(rt runtime) runner.Cmd {
 	return runner.Cmd{
 		Silent: true,
 		Args: []string{
-			dockerExe,
+			rt.exe,
 			"kill",
 			a.Name,
 		},
 	}
 }
 
-func (a Applet) pullCmd() runner.Cmd {
+func (a Applet) pullCmd(rt runtime) runner.Cmd {
 	args := []string{
-		dockerExe,
+		rt.exe,
 		"pull",
 	}
 
-	if a.Tag != "" {
-		args = append(args, fmt.Sprintf("%s:%s", a.Image, a.Tag))
-	} else {
-		args = append(args, a.Image)
-	}
+	args = append(args, a.ref())
 
 	return runner.Cmd{
 		Args: args,
 	}
 }
 
-func (a Applet) runCmd(extra ...string) runner.Cmd {
+func (a Applet) runCmd(rt runtime, extra ...string) runner.Cmd {
 	args := []string{
-		dockerExe,
+		rt.exe,
 		"run",
 	}
 
@@ -228,6 +382,46 @@ func (a Applet) runCmd(extra ...string) runner.Cmd {
 		args = append(args, "--hostname", a.Hostname)
 	}
 
+	if a.User != "" {
+		args = append(args, "--user", a.User)
+	}
+
+	if a.HostUser {
+		args = append(args, rt.hostUser...)
+	}
+
+	for _, f := range a.GroupAdd {
+		args = append(args, "--group-add", f)
+	}
+
+	if a.Memory != "" {
+		args = append(args, "--memory", a.Memory)
+	}
+
+	if a.MemorySwap != "" {
+		args = append(args, "--memory-swap", a.MemorySwap)
+	}
+
+	if a.CPUs != "" {
+		args = append(args, "--cpus", a.CPUs)
+	}
+
+	if a.CPUShares != 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(a.CPUShares, 10))
+	}
+
+	if a.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(a.PidsLimit, 10))
+	}
+
+	if a.ShmSize != "" {
+		args = append(args, "--shm-size", a.ShmSize)
+	}
+
+	if a.PullPolicy == pullNever {
+		args = append(args, "--pull", pullNever)
+	}
+
 	if a.RM {
 		args = append(args, "--rm")
 	}
@@ -236,6 +430,10 @@ func (a Applet) runCmd(extra ...string) runner.Cmd {
 		args = append(args, "--privileged")
 	}
 
+	if a.ReadOnly {
+		args = append(args, "--read-only")
+	}
+
 	if a.Detach {
 		args = append(args, "--detach")
 	}
@@ -268,6 +466,10 @@ func (a Applet) runCmd(extra ...string) runner.Cmd {
 		args = append(args, "-v", f)
 	}
 
+	if a.NetworkMode != "" {
+		args = append(args, "--network", a.NetworkMode)
+	}
+
 	for _, f := range a.Networks {
 		args = append(args, "--network", f)
 	}
@@ -284,12 +486,36 @@ func (a Applet) runCmd(extra ...string) runner.Cmd {
 		args = append(args, "--link", f)
 	}
 
-	if a.Tag != "" {
-		args = append(args, fmt.Sprintf("%s:%s", a.Image, a.Tag))
-	} else {
-		args = append(args, a.Image)
+	for _, f := range a.Labels {
+		args = append(args, "--label", f)
+	}
+
+	for _, f := range a.Ulimits.args() {
+		args = append(args, "--ulimit", f)
 	}
 
+	for _, f := range a.CapAdd {
+		args = append(args, "--cap-add", f)
+	}
+
+	for _, f := range a.CapDrop {
+		args = append(args, "--cap-drop", f)
+	}
+
+	for _, f := range a.SecurityOpt {
+		args = append(args, "--security-opt", f)
+	}
+
+	if a.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+
+	for _, f := range a.Tmpfs {
+		args = append(args, "--tmpfs", f)
+	}
+
+	args = append(args, a.ref())
+
 	if len(a.Command) != 0 {
 		args = append(args, a.Command...)
 	}
@@ -301,28 +527,76 @@ func (a Applet) runCmd(extra ...string) runner.Cmd {
 	}
 }
 
-func (a Applet) appletCmds(extra ...string) []runner.Cmd {
+// appletCmds compiles the commands of a single applet. source is "applet"
+// for the invoked applet and the kind of hook otherwise.
+func (a Applet) appletCmds(cfg *dockerbox.Config, rt runtime, source string, extra ...string) ([]runner.Cmd, error) {
+	if a.Hardened {
+		a = a.harden()
+	}
+
+	a = a.withHostWorkDir(cfg.WD)
+
+	if a.passesEnv() {
+		var err error
+		a, err = a.withHostEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if a.HostUser {
+		a = a.withHostUser(cfg.Host)
+	}
+
 	commands := []runner.Cmd{}
-	if a.Pull {
+	if a.Build != nil {
 		commands = append(
 			commands,
-			a.pullCmd(),
+			a.buildCmd(rt),
 		)
 	}
 
+	pulls, err := a.pullCmds(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	commands = append(commands, pulls...)
+
+	if cfg.Offline {
+		a.PullPolicy = pullNever
+	}
+
 	if a.Kill {
 		commands = append(
 			commands,
-			a.killCmd(),
+			a.killCmd(rt),
 		)
 	}
 
+	var run runner.Cmd
+	if a.Persistent {
+		name, ensure := a.ensureCmd(cfg, rt)
+		commands = append(commands, ensure)
+		run = a.execCmd(rt, name, extra...)
+	} else {
+		run = a.runCmd(rt, extra...)
+	}
+	run.Main = source == "applet"
+	if a.RewriteOutput {
+		run.Rewrites = a.outputRewrites()
+	}
+
 	commands = append(
 		commands,
-		a.runCmd(extra...),
+		run,
 	)
 
-	return commands
+	for i := range commands {
+		commands[i].Source = source + " " + a.AppletName
+	}
+
+	return commands, nil
 }
 
 func (a Applet) validateRequired() error {
@@ -330,7 +604,7 @@ func (a Applet) validateRequired() error {
 		return fmt.Errorf("applet_name is required")
 	}
 
-	if a.Image == "" {
+	if a.Image == "" && a.Build == nil {
 		return fmt.Errorf("image is required")
 	}
 
@@ -377,9 +651,9 @@ func (a Applet) validateHooks(applets Applets) error {
 	return validate(a, map[string]bool{})
 }
 
-func (v Volume) createVolumeCmd() runner.Cmd {
+func (v Volume) createVolumeCmd(rt runtime) runner.Cmd {
 	args := []string{
-		dockerExe,
+		rt.exe,
 		"volume",
 		"create",
 	}
@@ -391,13 +665,15 @@ func (v Volume) createVolumeCmd() runner.Cmd {
 	args = append(args, v.Name)
 
 	return runner.Cmd{
-		Args: args,
+		Source: "volume " + v.Name,
+		Args:   args,
+		Unless: []string{rt.exe, "volume", "inspect", v.Name},
 	}
 }
 
-func (n Network) createNetworkCmd() runner.Cmd {
+func (n Network) createNetworkCmd(rt runtime) runner.Cmd {
 	args := []string{
-		dockerExe,
+		rt.exe,
 		"network",
 		"create",
 	}
@@ -409,19 +685,12 @@ func (n Network) createNetworkCmd() runner.Cmd {
 	args = append(args, n.Name)
 
 	return runner.Cmd{
-		Args: args,
+		Source: "network " + n.Name,
+		Args:   args,
+		Unless: []string{rt.exe, "network", "inspect", n.Name},
 	}
 }
 
 func isTTY() bool {
 	return term.IsTerminal(int(os.Stdin.Fd()))
 }
-
-func splitArgs(separator string, args []string) ([]string, []string) {
-	for i, arg := range args {
-		if arg == separator {
-			return args[:i], args[i+1:]
-		}
-	}
-	return []string{}, args
-}
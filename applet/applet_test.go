@@ -2,7 +2,10 @@ package applet
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sethpollack/dockerbox/dockerbox"
 	"github.com/sethpollack/dockerbox/runner"
@@ -169,75 +172,940 @@ func TestCompile(t *testing.T) {
 				},
 			},
 			cmds: []runner.Cmd{
-				{Args: []string{"docker", "pull", "test"}},
-				{Args: []string{"docker", "run", "test"}},
+				{Source: "applet test", Args: []string{"docker", "pull", "test"}, Stamp: &runner.Stamp{File: "/root/.dockerbox/state/pulls.json", Key: "test"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "test"}},
 			},
 			cfg: &dockerbox.Config{
 				EntryPoint: "test",
+				RootDir:    "/root/.dockerbox",
 			},
 			err: nil,
 		},
+		{
+			name: "pull policy max age",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Tag:        "1",
+						PullPolicy: "daily",
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "applet test", Args: []string{"docker", "pull", "test:1"}, Stamp: &runner.Stamp{File: "/root/.dockerbox/state/pulls.json", Key: "test:1", MaxAge: 24 * time.Hour}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "test:1"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				RootDir:    "/root/.dockerbox",
+			},
+			err: nil,
+		},
+		{
+			name: "pull policy never",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--pull", "never", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--pull-policy", "never", "--"},
+				Separator:  "--",
+			},
+			err: nil,
+		},
+		{
+			name: "offline",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						PullPolicy: "always",
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--pull", "never", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Offline:    true,
+			},
+			err: nil,
+		},
+		{
+			name: "locked image",
+			root: Root{
+				Locked: map[string]string{
+					"test:1": "test@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				},
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Tag:        "1",
+						Pull:       true,
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "applet test", Args: []string{"docker", "pull", "test@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}, Stamp: &runner.Stamp{File: "state/pulls.json", Key: "test@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "test@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "rejects locked images of other repositories",
+			root: Root{
+				Locked: map[string]string{
+					"test:1": "evil/img@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				},
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Tag:        "1",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to get applet commands: failed to get commands: invalid lock entry for test:1: evil/img@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa is not a sha256 digest of test"),
+		},
+		{
+			name: "rejects locked references without a digest",
+			root: Root{
+				Locked: map[string]string{
+					"test:1": "test:2",
+				},
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Tag:        "1",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to get applet commands: failed to get commands: invalid lock entry for test:1: test:2 is not a sha256 digest of test"),
+		},
+		{
+			name: "validates pull policy",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						PullPolicy: "weekly",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: invalid pull_policy weekly: expected missing, always, never, daily or a duration"),
+		},
+		{
+			name: "validates the pull policy of hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						BeforeHooks: []Applet{{AppletName: "hook"}},
+					},
+					"hook": {
+						AppletName: "hook",
+						Image:      "hook",
+						PullPolicy: "dayly",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: hook hook: invalid pull_policy dayly: expected missing, always, never, daily or a duration"),
+		},
 		{
 			name: "kill arg",
 			root: Root{
 				Applets: map[string]Applet{
 					"test": {
 						AppletName: "test",
-						Name:       "test",
+						Name:       "test",
+						Image:      "test",
+						Kill:       true,
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Silent: true, Source: "applet test", Args: []string{"docker", "kill", "test"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--name", "test", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"before": {
+						AppletName: "before",
+						Image:      "before",
+					},
+					"after": {
+						AppletName: "after",
+						Image:      "after",
+					},
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						BeforeHooks: []Applet{
+							{
+								AppletName: "before",
+								Image:      "before",
+							},
+						},
+						AfterHooks: []Applet{
+							{
+								AppletName: "after",
+								Image:      "after",
+							},
+						},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "before hook before", Args: []string{"docker", "run", "before"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "test"}},
+				{Source: "after hook after", Args: []string{"docker", "run", "after"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "runtime from config",
+			root: Root{
+				Runtime: "podman",
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Name:       "test",
+						Image:      "test",
+						Kill:       true,
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Silent: true, Source: "applet test", Args: []string{"nerdctl", "kill", "test"}},
+				{Main: true, Source: "applet test", Args: []string{"nerdctl", "run", "--name", "test", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Runtime:    "nerdctl",
+			},
+			err: nil,
+		},
+		{
+			name: "runtime from root",
+			root: Root{
+				Runtime: "/usr/local/bin/podman",
+				Networks: map[string]Network{
+					"test": {
+						Name: "test",
+					},
+				},
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Networks:   []string{"test"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "network test", Args: []string{"/usr/local/bin/podman", "network", "create", "test"}, Unless: []string{"/usr/local/bin/podman", "network", "inspect", "test"}},
+				{Main: true, Source: "applet test", Args: []string{"/usr/local/bin/podman", "run", "--network", "test", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "applet runtime overrides",
+			root: Root{
+				Runtime: "podman",
+				Applets: map[string]Applet{
+					"before": {
+						AppletName: "before",
+						Image:      "before",
+					},
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Runtime:    "docker",
+						BeforeHooks: []Applet{
+							{
+								AppletName: "before",
+								Image:      "before",
+							},
+						},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "before hook before", Args: []string{"nerdctl", "run", "before"}},
+				{Main: true, Source: "applet test", Args: []string{"podman", "run", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Runtime:    "nerdctl",
+				Args:       []string{"--runtime", "podman", "--"},
+				Separator:  "--",
+			},
+			err: nil,
+		},
+		{
+			name: "only used resources",
+			root: Root{
+				Volumes: map[string]Volume{
+					"bundle": {
+						Name: "bundle",
+					},
+					"unused": {
+						Name: "unused",
+					},
+				},
+				Networks: map[string]Network{
+					"backend": {
+						Name: "dev_backend",
+					},
+					"unused": {
+						Name: "unused",
+					},
+				},
+				Applets: map[string]Applet{
+					"before": {
+						AppletName: "before",
+						Image:      "before",
+						Networks:   []string{"dev_backend"},
+					},
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Networks:   []string{"dev_backend"},
+						Volumes:    []string{"bundle:/usr/local/bundle", "/src:/src"},
+						BeforeHooks: []Applet{
+							{
+								AppletName: "before",
+								Image:      "before",
+							},
+						},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Source: "network dev_backend", Args: []string{"docker", "network", "create", "dev_backend"}, Unless: []string{"docker", "network", "inspect", "dev_backend"}},
+				{Source: "volume bundle", Args: []string{"docker", "volume", "create", "bundle"}, Unless: []string{"docker", "volume", "inspect", "bundle"}},
+				{Source: "before hook before", Args: []string{"docker", "run", "--network", "dev_backend", "before"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "-v", "bundle:/usr/local/bundle", "-v", "/src:/src", "--network", "dev_backend", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "resource limits",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Memory:     "512m",
+						MemorySwap: "1g",
+						CPUs:       "1.5",
+						CPUShares:  512,
+						PidsLimit:  100,
+						ShmSize:    "64m",
+						Ulimits: Ulimits{
+							"nproc":  {Soft: 512},
+							"nofile": {Soft: 1024, Hard: 2048},
+						},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--memory", "1g", "--memory-swap", "1g", "--cpus", "1.5", "--cpu-shares", "512", "--pids-limit", "100", "--shm-size", "64m", "--ulimit", "nofile=4096:4096", "--ulimit", "nproc=512:512", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--memory", "1g", "--ulimit", "nofile=4096", "--"},
+				Separator:  "--",
+			},
+			err: nil,
+		},
+		{
+			name: "validates memory",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Memory:     "lots",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New(`failed to validate applet: memory: invalid size "lots"`),
+		},
+		{
+			name: "validates memory swap",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						MemorySwap: "-1",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: memory_swap requires memory to be set"),
+		},
+		{
+			name: "validates cpus",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						CPUs:       "half",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New(`failed to validate applet: cpus: invalid number of cpus "half"`),
+		},
+		{
+			name: "validates ulimits",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Ulimits: Ulimits{
+							"nofile": {Soft: 2048, Hard: 1024},
+						},
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New(`failed to validate applet: ulimits: invalid ulimit "nofile=2048:1024": soft limit exceeds hard limit`),
+		},
+		{
+			name: "security options",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:      "test",
+						Image:           "test",
+						NetworkMode:     "none",
+						ReadOnly:        true,
+						NoNewPrivileges: true,
+						CapAdd:          []string{"NET_ADMIN"},
+						CapDrop:         []string{"MKNOD"},
+						SecurityOpt:     []string{"seccomp=unconfined"},
+						Tmpfs:           []string{"/run:size=64m"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--read-only", "--network", "none", "--cap-add", "NET_ADMIN", "--cap-drop", "MKNOD", "--security-opt", "seccomp=unconfined", "--security-opt", "no-new-privileges", "--tmpfs", "/run:size=64m", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "hardened",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						CapAdd:     []string{"CHOWN"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--read-only", "--cap-add", "CHOWN", "--cap-drop", "ALL", "--security-opt", "no-new-privileges", "--tmpfs", "/tmp", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--hardened", "--"},
+				Separator:  "--",
+			},
+			err: nil,
+		},
+		{
+			name: "validates hardened privileged",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Hardened:   true,
+						Privileged: true,
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: hardened applets can't be privileged"),
+		},
+		{
+			name: "validates network mode",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						NetworkMode: "none",
+						Ports:       []string{"80:80"},
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: network_mode none can't publish ports"),
+		},
+		{
+			name: "host user",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						HostUser:   true,
+						HostGroups: true,
+						Env:        []string{"FOO=bar"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--user", "1000:1000", "--group-add", "998", "--group-add", "999", "-e", "FOO=bar", "-e", "HOME=/tmp", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Host:       dockerbox.Host{UID: 1000, GID: 1000, Groups: []int{998, 999}},
+			},
+			err: nil,
+		},
+		{
+			name: "host user on podman",
+			root: Root{
+				Runtime: "podman",
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Env:        []string{"HOME=/home/test"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"podman", "run", "--user", "1000:1000", "--userns", "keep-id", "-e", "HOME=/home/test", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--host-user", "--"},
+				Separator:  "--",
+				Host:       dockerbox.Host{UID: 1000, GID: 1000},
+			},
+			err: nil,
+		},
+		{
+			name: "validates host user",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						User:       "root",
+						HostUser:   true,
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: user and host_user can't be combined"),
+		},
+		{
+			name: "env passthrough",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						EnvFilter:  "DBX_TEST_*",
+						Env:        []string{"DBX_TEST_B=override"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "-e", "DBX_TEST_A", "-e", "DBX_TEST_C", "-e", "DBX_TEST_B=override", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "env passthrough regexp",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						AllEnvs:    true,
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "-e", "DBX_TEST_A", "-e", "DBX_TEST_C", "test"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--env-filter", "/^(DBX_TEST_[AC]|HOME|PATH)$/", "--"},
+				Separator:  "--",
+			},
+			err: nil,
+		},
+		{
+			name: "rewrite output",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:    "test",
+						Image:         "test",
+						RewriteOutput: true,
+						Volumes:       []string{"/home/me/project:/src", "/home/me/project/vendor:/usr/lib/vendor", "/data:/data", "cache:/cache"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{
+					Main:   true,
+					Source: "applet test",
+					Args:   []string{"docker", "run", "-v", "/home/me/project:/src", "-v", "/home/me/project/vendor:/usr/lib/vendor", "-v", "/data:/data", "-v", "cache:/cache", "test"},
+					Rewrites: []runner.Rewrite{
+						{From: "/src", To: "/home/me/project"},
+						{From: "/usr/lib/vendor", To: "/home/me/project/vendor"},
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: nil,
+		},
+		{
+			name: "project scoped names",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
 						Image:      "test",
+						Name:       "{{project}}-{{applet}}",
 						Kill:       true,
+						Networks:   []string{"backend"},
+						Volumes:    []string{"node:/src/node_modules", "cache:/cache"},
+						BeforeHooks: []Applet{
+							{AppletName: "before"},
+						},
+					},
+					"before": {
+						AppletName: "before",
+						Image:      "before",
+						Name:       "before",
+						Scope:      "global",
+						Volumes:    []string{"node:/src/node_modules"},
 					},
 				},
+				Scope: "project",
+				Volumes: map[string]Volume{
+					"node":  {Name: "node"},
+					"cache": {Name: "cache", Scope: "global"},
+				},
+				Networks: map[string]Network{
+					"backend": {Name: "{{repo}}_backend"},
+				},
 			},
 			cmds: []runner.Cmd{
-				{Silent: true, Args: []string{"docker", "kill", "test"}},
-				{Args: []string{"docker", "run", "--name", "test", "test"}},
+				{Source: "volume project-225df309-node", Args: []string{"docker", "volume", "create", "project-225df309-node"}, Unless: []string{"docker", "volume", "inspect", "project-225df309-node"}},
+				{Source: "network project_backend", Args: []string{"docker", "network", "create", "project_backend"}, Unless: []string{"docker", "network", "inspect", "project_backend"}},
+				{Source: "volume cache", Args: []string{"docker", "volume", "create", "cache"}, Unless: []string{"docker", "volume", "inspect", "cache"}},
+				{Source: "before hook before", Args: []string{"docker", "run", "--name", "before", "-v", "project-225df309-node:/src/node_modules", "before"}},
+				{Silent: true, Source: "applet test", Args: []string{"docker", "kill", "225df309-test"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--name", "225df309-test", "-v", "project-225df309-node:/src/node_modules", "-v", "cache:/cache", "--network", "project_backend", "test"}},
 			},
 			cfg: &dockerbox.Config{
 				EntryPoint: "test",
+				ProjectDir: "/home/me/project",
 			},
 			err: nil,
 		},
 		{
-			name: "hooks",
+			name: "validates scope",
 			root: Root{
 				Applets: map[string]Applet{
-					"before": {
-						AppletName: "before",
-						Image:      "before",
+					"test": {
+						AppletName: "test",
+						Image:      "test",
 					},
-					"after": {
-						AppletName: "after",
-						Image:      "after",
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Args:       []string{"--scope", "local", "--"},
+				Separator:  "--",
+			},
+			err: errors.New("failed to validate applet: invalid scope local for applet test: must be project or global"),
+		},
+		{
+			name: "validates the scope of hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						AfterHooks: []Applet{{AppletName: "hook"}},
 					},
+					"hook": {
+						AppletName: "hook",
+						Image:      "hook",
+						Scope:      "local",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: hook hook: invalid scope local for applet hook: must be project or global"),
+		},
+		{
+			name: "validates env filter",
+			root: Root{
+				Applets: map[string]Applet{
 					"test": {
 						AppletName: "test",
 						Image:      "test",
-						BeforeHooks: []Applet{
-							{
-								AppletName: "before",
-								Image:      "before",
-							},
-						},
-						AfterHooks: []Applet{
-							{
-								AppletName: "after",
-								Image:      "after",
-							},
-						},
+						Inverse:    true,
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: inverse requires env_filter"),
+		},
+		{
+			name: "validates the env filter of hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						BeforeHooks: []Applet{{AppletName: "hook"}},
+					},
+					"hook": {
+						AppletName: "hook",
+						Image:      "hook",
+						EnvFilter:  "/[/",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: hook hook: invalid env_filter /[/: error parsing regexp: missing closing ]: `[`"),
+		},
+		{
+			name: "validates the limits of hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						AfterHooks: []Applet{{AppletName: "hook"}},
+					},
+					"hook": {
+						AppletName: "hook",
+						Image:      "hook",
+						Memory:     "lots",
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New(`failed to validate applet: hook hook: memory: invalid size "lots"`),
+		},
+		{
+			name: "validates runtime dialect",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Links:      []string{"db"},
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				Runtime:    "podman",
+			},
+			err: errors.New("failed to validate applet: podman does not support links (applet test)"),
+		},
+		{
+			name: "persistent",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						Entrypoint:  "test",
+						WorkDir:     "/src",
+						Interactive: true,
+						RM:          true,
+						Persistent:  true,
+						Env:         []string{"FOO=bar"},
+						Volumes:     []string{"/src:/src"},
+					},
+				},
+			},
+			cmds: []runner.Cmd{
+				{
+					Source: "applet test",
+					Args:   []string{"docker", "run", "--name", "dockerbox-test-3a065124a95c", "--entrypoint", "sleep", "--detach", "-v", "/src:/src", "--label", "dockerbox.applet=test", "--label", "dockerbox.idle-timeout=1h0m0s", "test", "infinity"},
+					Unless: []string{"docker", "start", "dockerbox-test-3a065124a95c"},
+					Stamp:  &runner.Stamp{File: "/root/.dockerbox/state/persistent.json", Key: "dockerbox-test-3a065124a95c"},
+				},
+				{Main: true, Source: "applet test", Args: []string{"docker", "exec", "--interactive", "--workdir", "/src", "-e", "FOO=bar", "dockerbox-test-3a065124a95c", "test", "my", "args"}},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+				RootDir:    "/root/.dockerbox",
+				Args:       []string{"my", "args"},
+			},
+			err: nil,
+		},
+		{
+			name: "persistent offline keeps the container",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						Entrypoint:  "test",
+						WorkDir:     "/src",
+						Interactive: true,
+						RM:          true,
+						Persistent:  true,
+						Env:         []string{"FOO=bar"},
+						Volumes:     []string{"/src:/src"},
 					},
 				},
 			},
 			cmds: []runner.Cmd{
-				{Args: []string{"docker", "run", "before"}},
-				{Args: []string{"docker", "run", "test"}},
-				{Args: []string{"docker", "run", "after"}},
+				{
+					Source: "applet test",
+					Args:   []string{"docker", "run", "--name", "dockerbox-test-3a065124a95c", "--entrypoint", "sleep", "--pull", "never", "--detach", "-v", "/src:/src", "--label", "dockerbox.applet=test", "--label", "dockerbox.idle-timeout=1h0m0s", "test", "infinity"},
+					Unless: []string{"docker", "start", "dockerbox-test-3a065124a95c"},
+					Stamp:  &runner.Stamp{File: "/root/.dockerbox/state/persistent.json", Key: "dockerbox-test-3a065124a95c"},
+				},
+				{Main: true, Source: "applet test", Args: []string{"docker", "exec", "--interactive", "--workdir", "/src", "-e", "FOO=bar", "dockerbox-test-3a065124a95c", "test", "my", "args"}},
 			},
 			cfg: &dockerbox.Config{
 				EntryPoint: "test",
+				RootDir:    "/root/.dockerbox",
+				Offline:    true,
+				Args:       []string{"my", "args"},
 			},
 			err: nil,
 		},
+		{
+			name: "validates persistent command",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName: "test",
+						Image:      "test",
+						Persistent: true,
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: persistent applets require an entrypoint or command"),
+		},
+		{
+			name: "validates persistent hooks",
+			root: Root{
+				Applets: map[string]Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						BeforeHooks: []Applet{{AppletName: "hook"}},
+					},
+					"hook": {
+						AppletName: "hook",
+						Image:      "hook",
+						Persistent: true,
+					},
+				},
+			},
+			cfg: &dockerbox.Config{
+				EntryPoint: "test",
+			},
+			err: errors.New("failed to validate applet: hook hook: persistent applets require an entrypoint or command"),
+		},
 		{
 			name: "invalid flags",
 			root: Root{
@@ -306,6 +1174,7 @@ func TestCompile(t *testing.T) {
 						Env:       []string{"test"},
 						EnvFile:   []string{"test"},
 						Links:     []string{"test"},
+						Labels:    []string{"test"},
 						Volumes:   []string{"test"},
 						Networks:  []string{"test"},
 						Ports:     []string{"test"},
@@ -328,13 +1197,13 @@ func TestCompile(t *testing.T) {
 				},
 			},
 			cmds: []runner.Cmd{
-				{Args: []string{"docker", "network", "create", "--driver", "test", "test"}},
-				{Args: []string{"docker", "volume", "create", "--driver", "test", "test"}},
-				{Args: []string{"docker", "run", "--name", "before", "before"}},
-				{Args: []string{"docker", "pull", "test:test"}},
-				{Silent: true, Args: []string{"docker", "kill", "test"}},
-				{Args: []string{"docker", "run", "--name", "test", "--workdir", "test", "--entrypoint", "test", "--restart", "test", "--hostname", "test", "--rm", "--privileged", "--detach", "--interactive", "--dns", "test", "--dns-search", "test", "--dns-option", "test", "-e", "test", "-v", "test", "--network", "test", "-p", "test", "--env-file", "test", "--link", "test", "test:test", "test", "my", "args"}},
-				{Args: []string{"docker", "run", "--name", "after", "after"}},
+				{Source: "network test", Args: []string{"docker", "network", "create", "--driver", "test", "test"}, Unless: []string{"docker", "network", "inspect", "test"}},
+				{Source: "volume test", Args: []string{"docker", "volume", "create", "--driver", "test", "test"}, Unless: []string{"docker", "volume", "inspect", "test"}},
+				{Source: "before hook before", Args: []string{"docker", "run", "--name", "before", "before"}},
+				{Source: "applet test", Args: []string{"docker", "pull", "test:test"}, Stamp: &runner.Stamp{File: "state/pulls.json", Key: "test:test"}},
+				{Silent: true, Source: "applet test", Args: []string{"docker", "kill", "test"}},
+				{Main: true, Source: "applet test", Args: []string{"docker", "run", "--name", "test", "--workdir", "test", "--entrypoint", "test", "--restart", "test", "--hostname", "test", "--rm", "--privileged", "--detach", "--interactive", "--dns", "test", "--dns-search", "test", "--dns-option", "test", "-e", "test", "-v", "test", "--network", "test", "-p", "test", "--env-file", "test", "--link", "test", "--label", "test", "test:test", "test", "my", "args"}},
+				{Source: "after hook after", Args: []string{"docker", "run", "--name", "after", "after"}},
 			},
 			cfg: &dockerbox.Config{
 				EntryPoint: "test",
@@ -345,6 +1214,10 @@ func TestCompile(t *testing.T) {
 		},
 	}
 
+	t.Setenv("DBX_TEST_A", "a")
+	t.Setenv("DBX_TEST_B", "b")
+	t.Setenv("DBX_TEST_C", "c")
+
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			actual, err := tc.root.Compile(tc.cfg)
@@ -354,3 +1227,283 @@ func TestCompile(t *testing.T) {
 		})
 	}
 }
+
+func TestWithHostEnvInverse(t *testing.T) {
+	t.Setenv("DBX_TEST_A", "a")
+	t.Setenv("DBX_TEST_B", "b")
+	t.Setenv("HOME", "/home/test")
+
+	a, err := Applet{AllEnvs: true, EnvFilter: "DBX_TEST_B", Inverse: true}.withHostEnv()
+	assert.Nil(t, err)
+
+	assert.Contains(t, a.Env, "DBX_TEST_A")
+	assert.NotContains(t, a.Env, "DBX_TEST_B")
+	assert.NotContains(t, a.Env, "HOME")
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("Dockerfile", "FROM alpine\n")
+	write("main.sh", "echo hi\n")
+	write(".dockerignore", "*.log\n")
+
+	root := Root{
+		Applets: map[string]Applet{
+			"test": {
+				AppletName: "test",
+				Build: &Build{
+					Context: dir,
+					Target:  "dev",
+					Args:    map[string]string{"B": "2", "A": "1"},
+				},
+			},
+		},
+	}
+	cfg := &dockerbox.Config{EntryPoint: "test"}
+
+	cmds, err := root.Compile(cfg)
+	assert.Nil(t, err)
+
+	tag := cmds[1].Args[len(cmds[1].Args)-1]
+	assert.Regexp(t, `^dockerbox/test:[0-9a-f]{12}$`, tag)
+	assert.Equal(t, []runner.Cmd{
+		{Source: "applet test", Args: []string{"docker", "build", "--file", filepath.Join(dir, "Dockerfile"), "--target", "dev", "--build-arg", "A=1", "--build-arg", "B=2", "--tag", tag, dir}, Unless: []string{"docker", "image", "inspect", tag}},
+		{Main: true, Source: "applet test", Args: []string{"docker", "run", tag}},
+	}, cmds)
+
+	write("debug.log", "ignored\n")
+	cmds, err = root.Compile(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, tag, cmds[1].Args[len(cmds[1].Args)-1])
+
+	write("main.sh", "echo bye\n")
+	cmds, err = root.Compile(cfg)
+	assert.Nil(t, err)
+	assert.NotEqual(t, tag, cmds[1].Args[len(cmds[1].Args)-1])
+}
+
+func TestBuildCache(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("Dockerfile", "FROM alpine\n")
+	write("main.sh", "echo hi\n")
+
+	root := Root{
+		Applets: map[string]Applet{
+			"test": {AppletName: "test", Build: &Build{Context: dir}},
+		},
+	}
+	cfg := &dockerbox.Config{EntryPoint: "test", Cache: true, RootDir: t.TempDir()}
+
+	tag := func() string {
+		t.Helper()
+		cmds, err := root.Compile(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmds[1].Args[len(cmds[1].Args)-1]
+	}
+
+	first := tag()
+	entries, err := os.ReadDir(filepath.Join(cfg.RootDir, "cache"))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Equal(t, first, tag())
+
+	write("main.sh", "echo bye\n")
+	assert.NotEqual(t, first, tag())
+}
+
+func TestDockerignore(t *testing.T) {
+	tt := []struct {
+		name     string
+		patterns string
+		ignored  []string
+		kept     []string
+		err      error
+	}{
+		{
+			name:     "matches globs and their contents",
+			patterns: "*.log\n/tmp\n# comment\n\nbuild?/\n",
+			ignored:  []string{"debug.log", "tmp", "tmp/a/b", "build1/out"},
+			kept:     []string{".git/HEAD", "main.go", "src/debug.log", "build/out", "build12"},
+		},
+		{
+			name:     "matches any number of directories with **",
+			patterns: "**/*.tmp\ndocs/**/draft.md\n",
+			ignored:  []string{"a.tmp", "src/b/a.tmp", "docs/draft.md", "docs/a/b/draft.md"},
+			kept:     []string{"a.tmpl", "src/draft.md"},
+		},
+		{
+			name:     "brings files back with exceptions",
+			patterns: "*.md\n!README*.md\nREADME-secret.md\nvendor\n!vendor/keep\n",
+			ignored:  []string{"CHANGES.md", "README-secret.md", "vendor/lib.go"},
+			kept:     []string{"README.md", "README-dev.md", "vendor/keep", "vendor/keep/a.go"},
+		},
+		{
+			name:     "fails on invalid patterns",
+			patterns: "[a-\n",
+			err:      errors.New("invalid .dockerignore pattern [a-: missing closing ]"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(tc.patterns), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ignore, err := readDockerignore(dir)
+			assert.Equal(t, tc.err, err)
+			if tc.err != nil {
+				return
+			}
+
+			for _, p := range tc.ignored {
+				assert.True(t, ignore.ignored(p), p)
+			}
+			for _, p := range tc.kept {
+				assert.False(t, ignore.ignored(p), p)
+			}
+		})
+	}
+}
+
+func TestRefs(t *testing.T) {
+	root := Root{
+		Applets: map[string]Applet{
+			"a":      {Image: "alpine", Tag: "latest"},
+			"b":      {Image: "alpine", Tag: "latest"},
+			"ruby":   {Image: "ruby", Tag: "3"},
+			"built":  {Image: "dockerbox/built", Build: &Build{Context: "."}},
+			"pinned": {Image: "node@sha256:abc"},
+		},
+	}
+
+	assert.Equal(t, []string{"alpine:latest", "ruby:3"}, root.Refs())
+}
+
+func TestRepoDigest(t *testing.T) {
+	tt := []struct {
+		image   string
+		digests []string
+		digest  string
+		err     error
+	}{
+		{image: "ruby", digests: []string{"ghcr.io/me/ruby@sha256:abc", "ruby@sha256:def"}, digest: "ruby@sha256:def"},
+		{image: "docker.io/library/alpine", digests: []string{"alpine@sha256:abc"}, digest: "docker.io/library/alpine@sha256:abc"},
+		{image: "ghcr.io/me/tool", digests: []string{"tool@sha256:abc"}, err: errors.New("none of the image's digests tool@sha256:abc belong to ghcr.io/me/tool")},
+		{image: "tool", err: errors.New("image has no digest, it was not pulled from a registry")},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.image, func(t *testing.T) {
+			digest, err := repoDigest(tc.image, tc.digests)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.digest, digest)
+		})
+	}
+}
+
+func TestWithHostWorkDir(t *testing.T) {
+	tt := []struct {
+		name    string
+		applet  Applet
+		wd      string
+		workDir string
+	}{
+		{
+			name:    "follows the cwd into the mount",
+			applet:  Applet{WorkDir: "/src", Volumes: []string{"/home/me/project:/src"}},
+			wd:      "/home/me/project/app/models",
+			workDir: "/src/app/models",
+		},
+		{
+			name:    "sets a missing work dir",
+			applet:  Applet{Volumes: []string{"/home/me/project:/src:ro"}},
+			wd:      "/home/me/project/app",
+			workDir: "/src/app",
+		},
+		{
+			name:    "prefers the most specific mount",
+			applet:  Applet{Volumes: []string{"/home/me/project/vendor:/vendor", "/home/me/project:/src", "bundle:/usr/local/bundle"}},
+			wd:      "/home/me/project/vendor/gem",
+			workDir: "/vendor/gem",
+		},
+		{
+			name:    "keeps a work dir outside the mount",
+			applet:  Applet{WorkDir: "/app", Volumes: []string{"/home/me/project:/src"}},
+			wd:      "/home/me/project/app",
+			workDir: "/app",
+		},
+		{
+			name:    "ignores a cwd outside the mounts",
+			applet:  Applet{WorkDir: "/src", Volumes: []string{"/home/me/project:/src"}},
+			wd:      "/home/me/projects",
+			workDir: "/src",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.workDir, tc.applet.withHostWorkDir(tc.wd).WorkDir)
+		})
+	}
+}
+
+func TestTranslateArgs(t *testing.T) {
+	project := t.TempDir()
+	err := os.MkdirAll(filepath.Join(project, "app"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(project, "app", "user.rb"), []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := Applet{Volumes: []string{project + ":/src"}}
+
+	assert.Equal(t, []string{
+		"/src/app/user.rb",
+		"--config=/src/app",
+		filepath.Join(project, "missing.rb"),
+		"/etc/hosts",
+		"app/user.rb",
+		"-c",
+		"/src/app/user.rb",
+	}, a.translateArgs([]string{
+		filepath.Join(project, "app", "user.rb"),
+		"--config=" + filepath.Join(project, "app"),
+		filepath.Join(project, "missing.rb"),
+		"/etc/hosts",
+		"app/user.rb",
+		"-c",
+		filepath.Join(project, "app", "user.rb"),
+	}))
+}
@@ -0,0 +1,367 @@
+package applet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sethpollack/dockerbox/cache"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/runner"
+	"github.com/spf13/afero"
+)
+
+// builtImageRepo is used for built images of applets without an image.
+const builtImageRepo = "dockerbox"
+
+// Build describes an image built from a local Dockerfile. Relative paths
+// are resolved against the directory of the config file defining them.
+type Build struct {
+	Context    string            `json:"context"`
+	Dockerfile string            `json:"dockerfile"`
+	Target     string            `json:"target"`
+	Args       map[string]string `json:"args"`
+}
+
+func (b *Build) dockerfile() string {
+	if b.Dockerfile != "" {
+		return b.Dockerfile
+	}
+
+	return filepath.Join(b.Context, "Dockerfile")
+}
+
+// withBuiltImage points the applet at the image built from its context,
+// tagged with a hash of everything that goes into the build so it is only
+// rebuilt when something changes.
+func (a Applet) withBuiltImage(cfg *dockerbox.Config) (Applet, error) {
+	var c *cache.Cache
+	if cfg.Cache && cfg.RootDir != "" {
+		c = cache.New(afero.NewOsFs(), cache.Dir(cfg.RootDir))
+	}
+
+	sum, err := a.Build.hash(c)
+	if err != nil {
+		return a, fmt.Errorf("failed to hash build context of %s: %v", a.AppletName, err)
+	}
+
+	if a.Image == "" {
+		a.Image = builtImageRepo + "/" + a.AppletName
+	}
+	a.Tag = sum[:12]
+
+	return a, nil
+}
+
+func (a Applet) buildCmd(rt runtime) runner.Cmd {
+	image := a.ref()
+
+	args := []string{
+		rt.exe,
+		"build",
+		"--file", a.Build.dockerfile(),
+	}
+
+	if a.Build.Target != "" {
+		args = append(args, "--target", a.Build.Target)
+	}
+
+	keys := make([]string, 0, len(a.Build.Args))
+	for k := range a.Build.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		args = append(args, "--build-arg", k+"="+a.Build.Args[k])
+	}
+
+	args = append(args, "--tag", image, a.Build.Context)
+
+	return runner.Cmd{
+		Args:   args,
+		Unless: []string{rt.exe, "image", "inspect", image},
+	}
+}
+
+// contextFile is a file of the build context that isn't ignored.
+type contextFile struct {
+	rel  string
+	path string
+	info fs.FileInfo
+}
+
+// hash covers the Dockerfile, the build options and every file in the
+// context that isn't excluded by its .dockerignore. With a cache the hash is
+// reused while the paths, sizes and modification times of those files stay
+// the same, so the context is only read when something changed.
+func (b *Build) hash(c *cache.Cache) (string, error) {
+	files, err := b.files()
+	if err != nil {
+		return "", err
+	}
+
+	options := sha256.New()
+	fmt.Fprintf(options, "%s\x00", b.Target)
+	keys := make([]string, 0, len(b.Args))
+	for k := range b.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(options, "%s=%s\x00", k, b.Args[k])
+	}
+
+	key := ""
+	if c != nil {
+		stats := sha256.New()
+		fmt.Fprintf(stats, "%x\x00", options.Sum(nil))
+
+		recent := false
+		for _, f := range files {
+			fmt.Fprintf(stats, "%s\x00%s\x00%d\x00%d\x00", f.rel, f.path, f.info.Size(), f.info.ModTime().UnixNano())
+			// a file changed within the timestamp's resolution may change
+			// again without its stat changing
+			recent = recent || time.Since(f.info.ModTime()) < 2*time.Second
+		}
+
+		var sum string
+		key = fmt.Sprintf("build-%x", stats.Sum(nil))
+		if c.Get(key, &sum) {
+			return sum, nil
+		}
+		if recent {
+			key = ""
+		}
+	}
+
+	h := sha256.New()
+	h.Write(options.Sum(nil))
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00", f.rel)
+
+		err := hashFile(h, f.path)
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+
+	if key != "" {
+		// the hash is known either way, a failed write only costs time
+		c.Put(key, sum)
+	}
+
+	return sum, nil
+}
+
+// files returns the Dockerfile and the files of the context that aren't
+// ignored, sorted by path.
+func (b *Build) files() ([]contextFile, error) {
+	info, err := os.Stat(b.dockerfile())
+	if err != nil {
+		return nil, err
+	}
+	files := []contextFile{{rel: "", path: b.dockerfile(), info: info}}
+
+	ignore, err := readDockerignore(b.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(b.Context, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.Context, path)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && ignore.ignored(rel) {
+			// exceptions can bring back files inside ignored directories
+			if d.IsDir() && !ignore.exceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, contextFile{rel: rel, path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// dockerignore matches paths against the patterns of a .dockerignore. Like
+// docker, the last matching pattern decides, patterns starting with `!`
+// bring back what earlier ones excluded and a pattern matching a directory
+// covers everything in it.
+type dockerignore struct {
+	rules      []ignoreRule
+	exceptions bool
+}
+
+type ignoreRule struct {
+	re        *regexp.Regexp
+	exception bool
+}
+
+// readDockerignore reads the context's .dockerignore. Without one nothing is
+// ignored.
+func readDockerignore(context string) (*dockerignore, error) {
+	d := &dockerignore{}
+
+	lines := []string{}
+
+	f, err := os.Open(filepath.Join(context, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.exception = true
+			d.exceptions = true
+			line = strings.TrimSpace(line[1:])
+		}
+
+		pattern := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(line, "/")))
+
+		rule.re, err = ignorePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %s: %v", line, err)
+		}
+
+		d.rules = append(d.rules, rule)
+	}
+
+	return d, nil
+}
+
+func (d *dockerignore) ignored(rel string) bool {
+	ignored := false
+	for _, r := range d.rules {
+		// only rules that would change the outcome need to be matched
+		if r.exception != ignored {
+			continue
+		}
+
+		if r.matches(filepath.ToSlash(rel)) {
+			ignored = !r.exception
+		}
+	}
+
+	return ignored
+}
+
+// matches reports whether the rule matches rel or one of its parents.
+func (r ignoreRule) matches(rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if r.re.MatchString(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ignorePattern compiles a .dockerignore pattern: `*` and `?` don't match
+// `/`, `**` matches any number of directories and `[...]` matches a class.
+func ignorePattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				re.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				re.WriteString(".*")
+				i++
+			default:
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing closing ]")
+			}
+
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,107 @@
+package applet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// excludedEnvs describe the host rather than the tool being run, and would
+// break most images if passed through.
+var excludedEnvs = map[string]bool{
+	"_":               true,
+	"HOME":            true,
+	"HOSTNAME":        true,
+	"LD_LIBRARY_PATH": true,
+	"LD_PRELOAD":      true,
+	"LOGNAME":         true,
+	"OLDPWD":          true,
+	"PATH":            true,
+	"PWD":             true,
+	"SHELL":           true,
+	"SHLVL":           true,
+	"TMPDIR":          true,
+	"USER":            true,
+}
+
+// envFilter matches variable names against a glob, or a regular expression
+// when wrapped in slashes, e.g. `/^AWS_/`.
+type envFilter func(string) bool
+
+func newEnvFilter(pattern string) (envFilter, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid env_filter %s: %v", pattern, err)
+		}
+
+		return re.MatchString, nil
+	}
+
+	_, err := filepath.Match(pattern, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid env_filter %s: %v", pattern, err)
+	}
+
+	return func(name string) bool {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	}, nil
+}
+
+// withHostEnv passes the host's environment variables through by name,
+// leaving their values for the runtime client to read. Variables set by the
+// applet itself are left alone.
+func (a Applet) withHostEnv() (Applet, error) {
+	match := func(string) bool { return true }
+	if a.EnvFilter != "" {
+		filter, err := newEnvFilter(a.EnvFilter)
+		if err != nil {
+			return a, err
+		}
+		match = func(name string) bool { return filter(name) != a.Inverse }
+	}
+
+	set := map[string]bool{}
+	for _, e := range a.Env {
+		name, _, _ := strings.Cut(e, "=")
+		set[name] = true
+	}
+
+	names := []string{}
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		if name == "" || excludedEnvs[name] || set[name] || !match(name) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	a.Env = append(names, a.Env...)
+
+	return a, nil
+}
+
+func (a Applet) passesEnv() bool {
+	return a.AllEnvs || a.EnvFilter != ""
+}
+
+func (a Applet) validateEnv() error {
+	if a.Inverse && a.EnvFilter == "" {
+		return fmt.Errorf("inverse requires env_filter")
+	}
+
+	if a.EnvFilter != "" {
+		_, err := newEnvFilter(a.EnvFilter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package applet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/units"
+)
+
+type Ulimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+// Ulimits maps a ulimit name, e.g. nofile, to its limits. As a flag it is
+// set with docker's `name=soft[:hard]` form.
+type Ulimits map[string]Ulimit
+
+func (u *Ulimits) Set(s string) error {
+	name, soft, hard, err := units.ParseUlimit(s)
+	if err != nil {
+		return err
+	}
+
+	if *u == nil {
+		*u = Ulimits{}
+	}
+	(*u)[name] = Ulimit{Soft: soft, Hard: hard}
+
+	return nil
+}
+
+func (u *Ulimits) String() string {
+	return strings.Join(u.args(), ",")
+}
+
+func (u *Ulimits) Type() string {
+	return "ulimit"
+}
+
+// args returns the ulimits in `name=soft:hard` form, sorted by name.
+func (u *Ulimits) args() []string {
+	args := []string{}
+
+	for name, l := range *u {
+		hard := l.Hard
+		if hard == 0 {
+			hard = l.Soft
+		}
+		args = append(args, fmt.Sprintf("%s=%d:%d", name, l.Soft, hard))
+	}
+	sort.Strings(args)
+
+	return args
+}
+
+func (a Applet) validateLimits() error {
+	if a.Memory != "" {
+		_, err := units.ParseBytes(a.Memory)
+		if err != nil {
+			return fmt.Errorf("memory: %v", err)
+		}
+	}
+
+	if a.ShmSize != "" {
+		_, err := units.ParseBytes(a.ShmSize)
+		if err != nil {
+			return fmt.Errorf("shm_size: %v", err)
+		}
+	}
+
+	if a.MemorySwap != "" {
+		if a.Memory == "" {
+			return fmt.Errorf("memory_swap requires memory to be set")
+		}
+
+		if a.MemorySwap != "-1" {
+			_, err := units.ParseBytes(a.MemorySwap)
+			if err != nil {
+				return fmt.Errorf("memory_swap: %v", err)
+			}
+		}
+	}
+
+	if a.CPUs != "" {
+		cpus, err := strconv.ParseFloat(a.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return fmt.Errorf("cpus: invalid number of cpus %q", a.CPUs)
+		}
+	}
+
+	for _, u := range a.Ulimits.args() {
+		_, _, _, err := units.ParseUlimit(u)
+		if err != nil {
+			return fmt.Errorf("ulimits: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package applet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+)
+
+// ref returns the image reference the applet runs.
+func (a Applet) ref() string {
+	if a.Tag != "" {
+		return fmt.Sprintf("%s:%s", a.Image, a.Tag)
+	}
+
+	return a.Image
+}
+
+var digestRe = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// withLockedImage pins the applet's image to its locked digest, if any. The
+// lock can only pin the applet's own repository, not swap in another image.
+func (a Applet) withLockedImage(locked map[string]string) (Applet, error) {
+	ref, ok := locked[a.ref()]
+	if !ok || !a.lockable() {
+		return a, nil
+	}
+
+	repo, digest, _ := strings.Cut(ref, "@")
+	if repo != a.Image || !digestRe.MatchString(digest) {
+		return a, fmt.Errorf("invalid lock entry for %s: %s is not a sha256 digest of %s", a.ref(), ref, a.Image)
+	}
+
+	a.Image, a.Tag = ref, ""
+
+	return a, nil
+}
+
+// lockable reports whether the applet's image can be pinned. Built images
+// only exist locally and images referenced by digest are pinned already.
+func (a Applet) lockable() bool {
+	return a.Build == nil && !strings.Contains(a.Image, "@")
+}
+
+// Refs returns the image references of every applet that can be locked.
+func (root *Root) Refs() []string {
+	seen := map[string]bool{}
+	refs := []string{}
+
+	for _, a := range root.Applets {
+		if !a.lockable() || seen[a.ref()] {
+			continue
+		}
+
+		seen[a.ref()] = true
+		refs = append(refs, a.ref())
+	}
+	sort.Strings(refs)
+
+	return refs
+}
+
+// ResolveDigests resolves every lockable image reference to a digest with
+// the local daemon, pulling the images that aren't available yet. With pull
+// every image is pulled first, so references resolve to what their
+// registry currently serves.
+func (root *Root) ResolveDigests(cfg *dockerbox.Config, pull bool) (map[string]string, error) {
+	digests := map[string]string{}
+
+	names := make([]string, 0, len(root.Applets))
+	for name := range root.Applets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a := root.Applets[name]
+		if !a.lockable() {
+			continue
+		}
+
+		if _, ok := digests[a.ref()]; ok {
+			continue
+		}
+
+		digest, err := resolveDigest(root.runtime(cfg, a), a, pull)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", a.ref(), err)
+		}
+
+		digests[a.ref()] = digest
+	}
+
+	return digests, nil
+}
+
+func resolveDigest(rt runtime, a Applet, pull bool) (string, error) {
+	inspect := func() ([]byte, error) {
+		return exec.Command(rt.exe, "image", "inspect", "--format", "{{json .RepoDigests}}", a.ref()).Output()
+	}
+
+	var out []byte
+	var err error
+	if !pull {
+		out, err = inspect()
+	}
+	if pull || err != nil {
+		pull := exec.Command(rt.exe, "pull", a.ref())
+		pull.Stdout, pull.Stderr = os.Stderr, os.Stderr
+
+		err := pull.Run()
+		if err != nil {
+			return "", fmt.Errorf("failed to pull: %v", err)
+		}
+
+		out, err = inspect()
+		if err != nil {
+			return "", fmt.Errorf("failed to inspect: %v", err)
+		}
+	}
+
+	digests := []string{}
+	err = json.Unmarshal(out, &digests)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse digests: %v", err)
+	}
+
+	return repoDigest(a.Image, digests)
+}
+
+// repoDigest picks the digest of image's repository out of an image's repo
+// digests, which the daemon lists with normalized repositories, e.g.
+// `alpine` for `docker.io/library/alpine`. The digest is returned with the
+// configured repository, as lock entries have to match it.
+func repoDigest(image string, digests []string) (string, error) {
+	if len(digests) == 0 {
+		return "", fmt.Errorf("image has no digest, it was not pulled from a registry")
+	}
+
+	for _, d := range digests {
+		repo, sum, _ := strings.Cut(d, "@")
+		if normalizeRepo(repo) == normalizeRepo(image) {
+			return image + "@" + sum, nil
+		}
+	}
+
+	return "", fmt.Errorf("none of the image's digests %s belong to %s", strings.Join(digests, ", "), image)
+}
+
+func normalizeRepo(repo string) string {
+	for _, prefix := range []string{"docker.io/", "index.docker.io/"} {
+		if strings.HasPrefix(repo, prefix) {
+			repo = strings.TrimPrefix(repo, prefix)
+			return strings.TrimPrefix(repo, "library/")
+		}
+	}
+
+	return strings.TrimPrefix(repo, "library/")
+}
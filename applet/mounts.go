@@ -0,0 +1,150 @@
+package applet
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/runner"
+)
+
+// bindMount is a volume flag mounting a host path, `host:container[:opts]`.
+type bindMount struct {
+	Host      string
+	Container string
+	Options   string
+}
+
+// parseBindMount reads a volume flag, reporting false for named and
+// anonymous volumes.
+func parseBindMount(v string) (bindMount, bool) {
+	parts := strings.SplitN(v, ":", 3)
+	if len(parts) < 2 || !filepath.IsAbs(parts[0]) {
+		return bindMount{}, false
+	}
+
+	m := bindMount{
+		Host:      filepath.Clean(parts[0]),
+		Container: path.Clean(parts[1]),
+	}
+	if len(parts) == 3 {
+		m.Options = parts[2]
+	}
+
+	return m, true
+}
+
+// containerPath maps a host path under the mount to its path inside the
+// container.
+func (m bindMount) containerPath(host string) (string, bool) {
+	rel, err := filepath.Rel(m.Host, filepath.Clean(host))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+
+	return path.Join(m.Container, filepath.ToSlash(rel)), true
+}
+
+// bindMounts returns the applet's bind mounts.
+func (a Applet) bindMounts() []bindMount {
+	mounts := []bindMount{}
+
+	for _, v := range a.Volumes {
+		if m, ok := parseBindMount(v); ok {
+			mounts = append(mounts, m)
+		}
+	}
+
+	return mounts
+}
+
+// containerPath maps a host path to its path inside the container through
+// the most specific bind mount covering it.
+func (a Applet) containerPath(host string) (string, bindMount, bool) {
+	var best bindMount
+	var found string
+
+	for _, m := range a.bindMounts() {
+		p, ok := m.containerPath(host)
+		if !ok || found != "" && len(m.Host) < len(best.Host) {
+			continue
+		}
+
+		best, found = m, p
+	}
+
+	return found, best, found != ""
+}
+
+// outputRewrites maps each bind mount's container path back to its host
+// path. Mounts at the container root or at the same path on both sides
+// are skipped since there is nothing to rewrite.
+func (a Applet) outputRewrites() []runner.Rewrite {
+	rewrites := []runner.Rewrite{}
+
+	for _, m := range a.bindMounts() {
+		if m.Container == "/" || m.Container == filepath.ToSlash(m.Host) {
+			continue
+		}
+
+		rewrites = append(rewrites, runner.Rewrite{From: m.Container, To: m.Host})
+	}
+
+	return rewrites
+}
+
+// withHostWorkDir follows the host's working directory into the container
+// when it lies inside a bind mount, so relative paths keep working from
+// subdirectories of a mounted project. A work_dir pointing somewhere other
+// than the mount is left alone.
+func (a Applet) withHostWorkDir(wd string) Applet {
+	if wd == "" {
+		return a
+	}
+
+	workDir, m, ok := a.containerPath(wd)
+	if !ok {
+		return a
+	}
+
+	if a.WorkDir != "" && path.Clean(a.WorkDir) != m.Container {
+		return a
+	}
+
+	a.WorkDir = workDir
+
+	return a
+}
+
+// translateArgs rewrites arguments naming existing host paths inside a bind
+// mount to their container paths, including the value of `--flag=path`.
+func (a Applet) translateArgs(args []string) []string {
+	translate := func(arg string) string {
+		if !filepath.IsAbs(arg) {
+			return arg
+		}
+
+		if _, err := os.Stat(arg); err != nil {
+			return arg
+		}
+
+		if p, _, ok := a.containerPath(arg); ok {
+			return p
+		}
+
+		return arg
+	}
+
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if flag, value, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(flag, "-") {
+			out[i] = flag + "=" + translate(value)
+			continue
+		}
+
+		out[i] = translate(arg)
+	}
+
+	return out
+}
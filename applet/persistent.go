@@ -0,0 +1,235 @@
+package applet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/runner"
+	"github.com/sethpollack/dockerbox/state"
+	"github.com/spf13/afero"
+)
+
+const (
+	defaultIdleTimeout = time.Hour
+	reapInterval       = time.Minute
+
+	appletLabel      = "dockerbox.applet"
+	idleTimeoutLabel = "dockerbox.idle-timeout"
+
+	// reapKey records the last reap alongside the containers' last use.
+	reapKey = "reap"
+)
+
+func persistentState(cfg *dockerbox.Config) string {
+	return filepath.Join(cfg.RootDir, "state", "persistent.json")
+}
+
+func (a Applet) idleTimeout() time.Duration {
+	d, err := time.ParseDuration(a.IdleTimeout)
+	if err != nil {
+		return defaultIdleTimeout
+	}
+
+	return d
+}
+
+func (a Applet) validatePersistent() error {
+	if !a.Persistent {
+		return nil
+	}
+
+	if a.Entrypoint == "" && len(a.Command) == 0 {
+		return fmt.Errorf("persistent applets require an entrypoint or command")
+	}
+
+	if a.IdleTimeout != "" {
+		_, err := time.ParseDuration(a.IdleTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid idle_timeout %s: %v", a.IdleTimeout, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureCmd starts the long-lived container of a persistent applet unless
+// it already exists. The container is named after a hash of everything it
+// is created with, so a config change results in a fresh container while
+// the stale one is left to be reaped. Pulling doesn't change the container,
+// so going offline keeps using it.
+func (a Applet) ensureCmd(cfg *dockerbox.Config, rt runtime) (string, runner.Cmd) {
+	c := a
+	c.Name, c.WorkDir, c.Entrypoint = "", "", "sleep"
+	c.Command, c.Env, c.EnvFile = []string{"infinity"}, nil, nil
+	c.Detach, c.RM, c.Interactive, c.TTY = true, false, false, false
+	c.Labels = append(
+		append([]string{}, a.Labels...),
+		appletLabel+"="+a.AppletName,
+		idleTimeoutLabel+"="+a.idleTimeout().String(),
+	)
+
+	h := c
+	h.PullPolicy, h.Pull = "", false
+	sum := sha256.Sum256([]byte(strings.Join(h.runCmd(rt).Args, "\x00")))
+	c.Name = fmt.Sprintf("dockerbox-%s-%x", a.AppletName, sum[:6])
+
+	cmd := c.runCmd(rt)
+	cmd.Unless = []string{rt.exe, "start", c.Name}
+	cmd.Stamp = &runner.Stamp{File: persistentState(cfg), Key: c.Name}
+
+	return c.Name, cmd
+}
+
+// execCmd runs the applet inside its long-lived container.
+func (a Applet) execCmd(rt runtime, container string, extra ...string) runner.Cmd {
+	args := []string{
+		rt.exe,
+		"exec",
+	}
+
+	if a.Interactive {
+		args = append(args, "--interactive")
+	}
+
+	if isTTY() && a.TTY {
+		args = append(args, "--tty")
+	}
+
+	if a.WorkDir != "" {
+		args = append(args, "--workdir", a.WorkDir)
+	}
+
+	for _, f := range a.Env {
+		args = append(args, "-e", f)
+	}
+
+	for _, f := range a.EnvFile {
+		args = append(args, "--env-file", f)
+	}
+
+	args = append(args, container)
+
+	if a.Entrypoint != "" {
+		args = append(args, a.Entrypoint)
+	}
+
+	args = append(args, a.Command...)
+	args = append(args, extra...)
+
+	return runner.Cmd{
+		Args: args,
+	}
+}
+
+// Reap removes persistent containers that have been idle for longer than
+// their timeout. It only runs for persistent applets, at most once every
+// reapInterval.
+func (root *Root) Reap(cfg *dockerbox.Config) error {
+	a, ok := root.Applets[cfg.EntryPoint]
+	if !ok || !a.Persistent {
+		return nil
+	}
+
+	fs := afero.NewOsFs()
+	path := persistentState(cfg)
+
+	stamps, err := state.Load(fs, path)
+	if err != nil {
+		return err
+	}
+
+	if time.Since(stamps[reapKey]) < reapInterval {
+		return nil
+	}
+
+	rt := root.runtime(cfg, a)
+
+	out, err := exec.Command(
+		rt.exe,
+		"ps",
+		"--all",
+		"--filter", "label="+idleTimeoutLabel,
+		"--format", fmt.Sprintf(`{{.Names}}\t{{.Label "%s"}}\t{{.CreatedAt}}`, idleTimeoutLabel),
+	).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list persistent containers: %v", err)
+	}
+
+	expired := []string{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(fields[1])
+		if err != nil {
+			continue
+		}
+
+		lastUsed := stamps[fields[0]]
+		created, err := time.Parse("2006-01-02 15:04:05 -0700 MST", fields[2])
+		if err == nil && created.After(lastUsed) {
+			lastUsed = created
+		}
+
+		if time.Since(lastUsed) > timeout {
+			expired = append(expired, fields[0])
+		}
+	}
+
+	expired, err = idle(rt, expired)
+	if err != nil {
+		return err
+	}
+
+	if len(expired) > 0 {
+		err := exec.Command(rt.exe, append([]string{"rm", "--force"}, expired...)...).Run()
+		if err != nil {
+			return fmt.Errorf("failed to remove idle containers: %v", err)
+		}
+
+		err = state.Delete(fs, path, expired...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return state.Touch(fs, path, reapKey)
+}
+
+// idle filters out the containers that are still running an exec, such as a
+// long build started before the timeout.
+func idle(rt runtime, containers []string) ([]string, error) {
+	if len(containers) == 0 {
+		return containers, nil
+	}
+
+	out, err := exec.Command(
+		rt.exe,
+		append([]string{"inspect", "--format", "{{len .ExecIDs}}"}, containers...)...,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect idle containers: %v", err)
+	}
+
+	execs := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(execs) != len(containers) {
+		return nil, fmt.Errorf("failed to inspect idle containers: unexpected output %q", out)
+	}
+
+	idle := []string{}
+	for i, c := range containers {
+		if execs[i] == "0" {
+			idle = append(idle, c)
+		}
+	}
+
+	return idle, nil
+}
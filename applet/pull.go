@@ -0,0 +1,81 @@
+package applet
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/runner"
+)
+
+const (
+	pullMissing = "missing"
+	pullAlways  = "always"
+	pullNever   = "never"
+	pullDaily   = "daily"
+)
+
+func pullState(cfg *dockerbox.Config) string {
+	return filepath.Join(cfg.RootDir, "state", "pulls.json")
+}
+
+// pullMaxAge returns how long a pulled image is used before it is pulled
+// again, and whether it is pulled at all. Zero means the image is pulled on
+// every run.
+func (a Applet) pullMaxAge() (time.Duration, bool, error) {
+	switch a.PullPolicy {
+	case pullAlways:
+		return 0, true, nil
+	case pullDaily:
+		return 24 * time.Hour, true, nil
+	case "", pullMissing, pullNever:
+		return 0, a.Pull, nil
+	}
+
+	d, err := time.ParseDuration(a.PullPolicy)
+	if err != nil || d <= 0 {
+		return 0, false, fmt.Errorf("invalid pull_policy %s: expected missing, always, never, daily or a duration", a.PullPolicy)
+	}
+
+	return d, true, nil
+}
+
+func (a Applet) validatePull() error {
+	if a.PullPolicy == pullNever && a.Pull {
+		return fmt.Errorf("pull can't be combined with pull_policy never")
+	}
+
+	_, pulls, err := a.pullMaxAge()
+	if err != nil {
+		return err
+	}
+
+	if pulls && a.Build != nil {
+		return fmt.Errorf("built images can't be pulled")
+	}
+
+	return nil
+}
+
+// pullCmds pulls the applet's image as its pull policy requires, recording
+// when each image was last pulled. Nothing is pulled when offline.
+func (a Applet) pullCmds(cfg *dockerbox.Config, rt runtime) ([]runner.Cmd, error) {
+	maxAge, ok, err := a.pullMaxAge()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || cfg.Offline {
+		return nil, nil
+	}
+
+	pull := a.pullCmd(rt)
+	pull.Stamp = &runner.Stamp{
+		File:   pullState(cfg),
+		Key:    pull.Args[len(pull.Args)-1],
+		MaxAge: maxAge,
+	}
+
+	return []runner.Cmd{pull}, nil
+}
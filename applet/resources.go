@@ -0,0 +1,85 @@
+package applet
+
+import (
+	"strings"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/runner"
+)
+
+// resourceCmds creates the networks and volumes used by an applet and its
+// hooks. Each create is guarded by an inspect so existing ones are skipped.
+func (root *Root) resourceCmds(cfg *dockerbox.Config, a Applet) []runner.Cmd {
+	cmds := []runner.Cmd{}
+	seen := map[string]bool{}
+
+	add := func(cmd runner.Cmd) {
+		key := strings.Join(cmd.Args, " ")
+		if !seen[key] {
+			seen[key] = true
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	var walk func(Applet)
+	walk = func(applet Applet) {
+		rt := root.runtime(cfg, applet)
+
+		for _, h := range applet.BeforeHooks {
+			walk(root.Applets[h.AppletName])
+		}
+
+		for _, n := range applet.Networks {
+			if network, ok := root.network(n); ok {
+				add(network.createNetworkCmd(rt))
+			}
+		}
+
+		for _, v := range applet.Volumes {
+			if vol, ok := root.volume(v); ok {
+				add(vol.createVolumeCmd(rt))
+			}
+		}
+
+		for _, h := range applet.AfterHooks {
+			walk(root.Applets[h.AppletName])
+		}
+	}
+
+	walk(a)
+
+	return cmds
+}
+
+// network finds a configured network by its key or its name.
+func (root *Root) network(name string) (Network, bool) {
+	if n, ok := root.Networks[name]; ok {
+		return n, true
+	}
+
+	for _, n := range root.Networks {
+		if n.Name == name {
+			return n, true
+		}
+	}
+
+	return Network{}, false
+}
+
+// volume finds the configured volume mounted by a `name[:path[:opts]]`
+// volume flag, by its key or its name.
+func (root *Root) volume(flag string) (Volume, bool) {
+	name, _, _ := strings.Cut(flag, ":")
+
+	if v, ok := root.Volumes[name]; ok {
+		return v, true
+	}
+
+	for _, v := range root.Volumes {
+		if v.Name == name {
+			return v, true
+		}
+	}
+
+	return Volume{}, false
+}
@@ -0,0 +1,56 @@
+package applet
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+const defaultRuntime = "docker"
+
+// runtime describes the client binary used to run an applet and the parts
+// of its flag dialect that differ from docker's.
+type runtime struct {
+	name string
+	exe  string
+
+	noLinks        bool
+	noVolumeDriver bool
+	// hostUser holds the flags mapping the host user into the container on
+	// top of --user, e.g. podman's rootless containers need keep-id for
+	// files in bind mounts to be owned by the user.
+	hostUser []string
+}
+
+var runtimes = map[string]runtime{
+	"docker":  {},
+	"podman":  {noLinks: true, hostUser: []string{"--userns", "keep-id"}},
+	"nerdctl": {noLinks: true, noVolumeDriver: true},
+}
+
+// newRuntime resolves a runtime by name or by the path to its client.
+// Unknown clients are assumed to speak docker's dialect.
+func newRuntime(exe string) runtime {
+	name := filepath.Base(exe)
+
+	rt := runtimes[name]
+	rt.name = name
+	rt.exe = exe
+
+	return rt
+}
+
+func (rt runtime) validateApplet(a Applet) error {
+	if rt.noLinks && len(a.Links) > 0 {
+		return fmt.Errorf("%s does not support links (applet %s)", rt.name, a.AppletName)
+	}
+
+	return nil
+}
+
+func (rt runtime) validateVolume(v Volume) error {
+	if rt.noVolumeDriver && v.Driver != "" {
+		return fmt.Errorf("%s does not support volume drivers (volume %s)", rt.name, v.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package applet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+)
+
+const (
+	scopeGlobal  = "global"
+	scopeProject = "project"
+
+	// projectPrefix is prepended to plain names in the project scope.
+	projectPrefix = "{{repo}}-{{project}}-"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// names resolves the templated names of containers, volumes and networks
+// for the project dockerbox was invoked in.
+type names struct {
+	project string
+	repo    string
+}
+
+func newNames(cfg *dockerbox.Config) names {
+	dir := cfg.ProjectDir
+	if dir == "" {
+		dir = cfg.WD
+	}
+
+	sum := sha256.Sum256([]byte(dir))
+
+	return names{
+		project: fmt.Sprintf("%x", sum[:4]),
+		repo:    repoName(dir),
+	}
+}
+
+// repoName is the name of the git checkout containing dir, falling back to
+// the name of dir itself.
+func repoName(dir string) string {
+	name := filepath.Base(dir)
+	if root := dockerbox.GitRoot(dir); root != "" {
+		name = filepath.Base(root)
+	}
+
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		return "root"
+	}
+
+	return name
+}
+
+// resolve expands the template in name. Plain names in the project scope
+// are prefixed with the repo name and project hash.
+func (n names) resolve(name, scope, applet string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	if scope == scopeProject && !strings.Contains(name, "{{") {
+		name = projectPrefix + name
+	}
+
+	t, err := template.New("name").Funcs(template.FuncMap{
+		"project": func() string { return n.project },
+		"repo":    func() string { return n.repo },
+		"applet":  func() string { return applet },
+	}).Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid name %s: %v", name, err)
+	}
+
+	b := &strings.Builder{}
+	err = t.Execute(b, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid name %s: %v", name, err)
+	}
+
+	return b.String(), nil
+}
+
+// scope resolves the scope of a name. The setting closest to it wins over
+// the root's, which defaults to global.
+func (root *Root) scope(scope string) string {
+	for _, s := range []string{scope, root.Scope} {
+		if s != "" {
+			return s
+		}
+	}
+
+	return scopeGlobal
+}
+
+func (root *Root) validateScope(a Applet) error {
+	check := func(scope, what string) error {
+		switch scope {
+		case "", scopeGlobal, scopeProject:
+			return nil
+		default:
+			return fmt.Errorf("invalid scope %s for %s: must be project or global", scope, what)
+		}
+	}
+
+	err := check(root.Scope, "root")
+	if err != nil {
+		return err
+	}
+
+	err = root.eachApplet(a, func(a Applet) error {
+		return check(a.Scope, "applet "+a.AppletName)
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, v := range root.Volumes {
+		err := check(v.Scope, "volume "+key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, n := range root.Networks {
+		err := check(n.Scope, "network "+key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scoped returns a copy of root and the applet with every container, volume
+// and network name used by the applet and its hooks resolved. Volume and
+// network references are rewritten to the resolved names.
+func (root *Root) scoped(cfg *dockerbox.Config, a Applet) (*Root, Applet, error) {
+	n := newNames(cfg)
+
+	scoped := *root
+	scoped.Volumes = map[string]Volume{}
+	scoped.Networks = map[string]Network{}
+	scoped.Applets = Applets{}
+
+	volumes := map[string]string{}
+	for key, v := range root.Volumes {
+		name, err := n.resolve(v.Name, root.scope(v.Scope), a.AppletName)
+		if err != nil {
+			return nil, a, fmt.Errorf("failed to resolve volume %s: %v", key, err)
+		}
+
+		volumes[v.Name] = name
+		v.Name = name
+		scoped.Volumes[key] = v
+	}
+	for key, v := range scoped.Volumes {
+		volumes[key] = v.Name
+	}
+
+	networks := map[string]string{}
+	for key, nw := range root.Networks {
+		name, err := n.resolve(nw.Name, root.scope(nw.Scope), a.AppletName)
+		if err != nil {
+			return nil, a, fmt.Errorf("failed to resolve network %s: %v", key, err)
+		}
+
+		networks[nw.Name] = name
+		nw.Name = name
+		scoped.Networks[key] = nw
+	}
+	for key, nw := range scoped.Networks {
+		networks[key] = nw.Name
+	}
+
+	resolve := func(applet Applet) (Applet, error) {
+		name, err := n.resolve(applet.Name, root.scope(applet.Scope), applet.AppletName)
+		if err != nil {
+			return applet, fmt.Errorf("failed to resolve container name of %s: %v", applet.AppletName, err)
+		}
+		applet.Name = name
+
+		applet.Volumes = append([]string{}, applet.Volumes...)
+		for i, v := range applet.Volumes {
+			name, rest, found := strings.Cut(v, ":")
+			if resolved, ok := volumes[name]; ok {
+				applet.Volumes[i] = resolved
+				if found {
+					applet.Volumes[i] += ":" + rest
+				}
+			}
+		}
+
+		applet.Networks = append([]string{}, applet.Networks...)
+		for i, nw := range applet.Networks {
+			if resolved, ok := networks[nw]; ok {
+				applet.Networks[i] = resolved
+			}
+		}
+
+		return applet, nil
+	}
+
+	for key, applet := range root.Applets {
+		scoped.Applets[key] = applet
+	}
+
+	var walk func(Applet) error
+	walk = func(applet Applet) error {
+		for _, h := range append(applet.BeforeHooks, applet.AfterHooks...) {
+			hook, err := resolve(root.Applets[h.AppletName])
+			if err != nil {
+				return err
+			}
+			scoped.Applets[h.AppletName] = hook
+
+			err = walk(root.Applets[h.AppletName])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	a, err := resolve(a)
+	if err != nil {
+		return nil, a, err
+	}
+
+	err = walk(a)
+	if err != nil {
+		return nil, a, err
+	}
+
+	return &scoped, a, nil
+}
@@ -0,0 +1,64 @@
+package applet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// harden applies the baseline of the hardened preset on top of the
+// applet's own settings.
+func (a Applet) harden() Applet {
+	if !containsFold(a.CapDrop, "ALL") {
+		a.CapDrop = append([]string{"ALL"}, a.CapDrop...)
+	}
+
+	a.NoNewPrivileges = true
+	a.ReadOnly = true
+
+	hasTmp := false
+	for _, t := range a.Tmpfs {
+		path, _, _ := strings.Cut(t, ":")
+		if path == "/tmp" {
+			hasTmp = true
+		}
+	}
+
+	// most tools need somewhere to write once the root fs is read only
+	if !hasTmp {
+		a.Tmpfs = append([]string{"/tmp"}, a.Tmpfs...)
+	}
+
+	return a
+}
+
+func (a Applet) validateSecurity() error {
+	if a.Hardened && a.Privileged {
+		return fmt.Errorf("hardened applets can't be privileged")
+	}
+
+	if a.NetworkMode != "" && len(a.Networks) > 0 {
+		return fmt.Errorf("network_mode %s can't be combined with networks", a.NetworkMode)
+	}
+
+	if a.NetworkMode == "none" && len(a.Ports) > 0 {
+		return fmt.Errorf("network_mode none can't publish ports")
+	}
+
+	for _, t := range a.Tmpfs {
+		if !strings.HasPrefix(t, "/") {
+			return fmt.Errorf("tmpfs %s must be an absolute path", t)
+		}
+	}
+
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,52 @@
+package applet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+)
+
+// hostHome is used as HOME for host users, whose home directory doesn't
+// exist in the image.
+const hostHome = "/tmp"
+
+// withHostUser runs the applet as the invoking user, so files it creates in
+// mounted directories are owned by them.
+func (a Applet) withHostUser(host dockerbox.Host) Applet {
+	a.User = fmt.Sprintf("%d:%d", host.UID, host.GID)
+
+	if a.HostGroups {
+		groups := []string{}
+		for _, g := range host.Groups {
+			groups = append(groups, strconv.Itoa(g))
+		}
+		a.GroupAdd = append(groups, a.GroupAdd...)
+	}
+
+	hasHome := false
+	for _, e := range a.Env {
+		if strings.HasPrefix(e, "HOME=") {
+			hasHome = true
+		}
+	}
+
+	if !hasHome {
+		a.Env = append(a.Env[:len(a.Env):len(a.Env)], "HOME="+hostHome)
+	}
+
+	return a
+}
+
+func (a Applet) validateUser() error {
+	if a.HostUser && a.User != "" {
+		return fmt.Errorf("user and host_user can't be combined")
+	}
+
+	if a.HostGroups && !a.HostUser {
+		return fmt.Errorf("host_groups requires host_user")
+	}
+
+	return nil
+}
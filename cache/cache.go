@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// maxEntries bounds the number of entries kept; the least recently written
+// are removed first.
+const maxEntries = 64
+
+// Cache stores JSON encoded values in a directory, one file per key.
+type Cache struct {
+	fs  afero.Fs
+	dir string
+}
+
+type Status struct {
+	Dir     string
+	Entries int
+	Size    int64
+	Newest  time.Time
+}
+
+// Dir returns the cache directory inside dockerbox's root dir.
+func Dir(rootDir string) string {
+	return filepath.Join(rootDir, "cache")
+}
+
+func New(fs afero.Fs, dir string) *Cache {
+	return &Cache{
+		fs:  fs,
+		dir: dir,
+	}
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get decodes the entry for key into v, reporting whether it was found.
+func (c *Cache) Get(key string, v any) bool {
+	bytes, err := afero.ReadFile(c.fs, c.path(key))
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(bytes, v) == nil
+}
+
+func (c *Cache) Put(key string, v any) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %v", err)
+	}
+
+	err = c.fs.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create cache dir %s: %v", c.dir, err)
+	}
+
+	tmp := fmt.Sprintf("%s.%d", c.path(key), os.Getpid())
+
+	err = afero.WriteFile(c.fs, tmp, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+
+	err = c.fs.Rename(tmp, c.path(key))
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+
+	return c.prune()
+}
+
+func (c *Cache) Clear() error {
+	err := c.fs.RemoveAll(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to clear cache dir %s: %v", c.dir, err)
+	}
+
+	return nil
+}
+
+func (c *Cache) Status() (*Status, error) {
+	status := &Status{Dir: c.dir}
+
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		status.Entries++
+		status.Size += e.Size()
+
+		if e.ModTime().After(status.Newest) {
+			status.Newest = e.ModTime()
+		}
+	}
+
+	return status, nil
+}
+
+func (c *Cache) entries() ([]os.FileInfo, error) {
+	dir, err := afero.ReadDir(c.fs, c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache dir %s: %v", c.dir, err)
+	}
+
+	entries := []os.FileInfo{}
+	for _, file := range dir {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			entries = append(entries, file)
+		}
+	}
+
+	return entries, nil
+}
+
+func (c *Cache) prune() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) <= maxEntries {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for _, e := range entries[:len(entries)-maxEntries] {
+		err := c.fs.Remove(filepath.Join(c.dir, e.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to prune cache entry %s: %v", e.Name(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/root/.dockerbox/cache")
+
+	v := map[string]string{}
+	assert.False(t, c.Get("key", &v))
+
+	err := c.Put("key", map[string]string{"foo": "bar"})
+	assert.Nil(t, err)
+
+	assert.True(t, c.Get("key", &v))
+	assert.Equal(t, map[string]string{"foo": "bar"}, v)
+
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, status.Entries)
+
+	err = c.Clear()
+	assert.Nil(t, err)
+	assert.False(t, c.Get("key", &v))
+}
+
+func TestCachePrune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/cache")
+
+	for i := 0; i < maxEntries+5; i++ {
+		err := c.Put(fmt.Sprintf("key%d", i), i)
+		assert.Nil(t, err)
+	}
+
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, maxEntries, status.Entries)
+}
@@ -0,0 +1,17 @@
+package catalog
+
+// #Base runs the tool named after the applet with the project mounted at
+// /src. Set #project to the host directory to mount, e.g. environ.PWD.
+// Tools add their own volumes, such as caches, with #volumes. The host's
+// environment stays on the host unless the applet sets all_envs.
+#Base: {
+	#project: string
+	#volumes: [...string] | *[]
+
+	applet_name: string
+	entrypoint:  string | *applet_name
+	work_dir:    string | *"/src"
+	all_envs:    bool | *false
+	volumes:     [...string] | *(#volumes + ["\(#project):/src"])
+	...
+}
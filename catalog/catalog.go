@@ -0,0 +1,116 @@
+// Package catalog embeds curated applet definitions for common tools,
+// which configs import as "dockerbox.dev/catalog/v1:catalog".
+package catalog
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+const (
+	// Version is bumped whenever a definition changes incompatibly, so
+	// configs keep importing the definitions they were written against.
+	Version = "v1"
+
+	ImportPath = "dockerbox.dev/catalog/" + Version
+	Import     = `import "` + ImportPath + `:catalog"`
+)
+
+//go:embed *.cue
+var files embed.FS
+
+// Tool describes a catalog definition and the applets a starter config
+// for it sets up.
+type Tool struct {
+	Name        string
+	Description string
+	Definition  string
+	Applets     []string
+	Volumes     []string
+	// Settings are definition fields the starter sets besides #project.
+	Settings []string
+}
+
+var tools = []Tool{
+	{Name: "go", Description: "Go toolchain", Definition: "#Go", Applets: []string{"go", "gofmt"}, Volumes: []string{"gomod", "gocache"}},
+	{Name: "jq", Description: "jq without network access", Definition: "#Jq", Applets: []string{"jq"}},
+	{Name: "kubectl", Description: "kubectl with the host's kube config", Definition: "#Kubectl", Applets: []string{"kubectl"}, Settings: []string{`#kubeconfig: "\(environ.HOME)/.kube/config"`}},
+	{Name: "node", Description: "Node.js, npm and yarn", Definition: "#Node", Applets: []string{"node", "npm", "npx", "yarn"}, Volumes: []string{"yarn", "node"}},
+	{Name: "python", Description: "Python and pip", Definition: "#Python", Applets: []string{"python", "pip"}, Volumes: []string{"pip"}},
+	{Name: "ruby", Description: "Ruby, Bundler, RSpec and RuboCop", Definition: "#Ruby", Applets: []string{"ruby", "bundle", "rspec", "rubocop"}, Volumes: []string{"bundle"}},
+	{Name: "rust", Description: "Rust and cargo", Definition: "#Rust", Applets: []string{"rustc", "cargo"}, Volumes: []string{"cargo"}},
+	{Name: "terraform", Description: "Terraform with a provider cache", Definition: "#Terraform", Applets: []string{"terraform"}, Volumes: []string{"terraform"}},
+}
+
+// Tools returns the catalog's tools sorted by name.
+func Tools() []Tool {
+	sorted := append([]Tool{}, tools...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+func Lookup(name string) (Tool, error) {
+	for _, t := range tools {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+
+	return Tool{}, fmt.Errorf("%s is not in the catalog", name)
+}
+
+// Files returns the catalog's CUE files by name.
+func Files() map[string][]byte {
+	out := map[string][]byte{}
+
+	entries, _ := fs.ReadDir(files, ".")
+	for _, e := range entries {
+		bytes, err := files.ReadFile(e.Name())
+		if err == nil {
+			out[e.Name()] = bytes
+		}
+	}
+
+	return out
+}
+
+// Source returns the CUE file defining the tool.
+func (t Tool) Source() []byte {
+	return Files()[t.Name+".cue"]
+}
+
+// Starter returns a config importing the tool's definition for each of its
+// applets, with the current directory as the project.
+func (t Tool) Starter() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "%s\n", Import)
+
+	if len(t.Volumes) > 0 {
+		fmt.Fprintf(b, "\nvolumes: {\n")
+		for _, v := range t.Volumes {
+			fmt.Fprintf(b, "\t%s: name: %q\n", v, v)
+		}
+		fmt.Fprintf(b, "}\n")
+	}
+
+	fmt.Fprintf(b, "\napplets: {\n")
+	for _, a := range t.Applets {
+		fmt.Fprintf(b, "\t%s: #Applet & catalog.%s & {\n", a, t.Definition)
+		fmt.Fprintf(b, "\t\t#project: environ.PWD\n")
+		for _, s := range t.Settings {
+			fmt.Fprintf(b, "\t\t%s\n", s)
+		}
+		fmt.Fprintf(b, "\n\t\tapplet_name: %q\n", a)
+		fmt.Fprintf(b, "\t}\n")
+	}
+	fmt.Fprintf(b, "}\n")
+
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package catalog
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools(t *testing.T) {
+	for _, tool := range Tools() {
+		assert.NotEmpty(t, tool.Source(), tool.Name)
+		assert.Contains(t, string(tool.Source()), tool.Definition+":", tool.Name)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tool, err := Lookup("ruby")
+	assert.Nil(t, err)
+	assert.Equal(t, "#Ruby", tool.Definition)
+
+	_, err = Lookup("cobol")
+	assert.Equal(t, errors.New("cobol is not in the catalog"), err)
+}
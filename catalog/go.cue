@@ -0,0 +1,11 @@
+package catalog
+
+// #Go keeps the module and build caches in volumes.
+#Go: #Base & {
+	#volumes: [
+		"gomod:/go/pkg/mod",
+		"gocache:/root/.cache/go-build",
+	]
+
+	image: string | *"golang"
+}
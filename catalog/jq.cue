@@ -0,0 +1,7 @@
+package catalog
+
+// #Jq runs jq without network access.
+#Jq: #Base & {
+	image:        string | *"stedolan/jq"
+	network_mode: string | *"none"
+}
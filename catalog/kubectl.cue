@@ -0,0 +1,10 @@
+package catalog
+
+// #Kubectl mounts the host's kube config read only. Set #kubeconfig to its
+// path, e.g. "\(environ.HOME)/.kube/config".
+#Kubectl: #Base & {
+	#kubeconfig: string
+	#volumes: ["\(#kubeconfig):/.kube/config:ro"]
+
+	image: string | *"bitnami/kubectl"
+}
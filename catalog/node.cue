@@ -0,0 +1,11 @@
+package catalog
+
+// #Node keeps global packages and the yarn cache in volumes.
+#Node: #Base & {
+	#volumes: [
+		"yarn:/root/.yarn",
+		"node:/usr/local/lib/node_modules",
+	]
+
+	image: string | *"node"
+}
@@ -0,0 +1,8 @@
+package catalog
+
+// #Python keeps the pip cache in a volume.
+#Python: #Base & {
+	#volumes: ["pip:/root/.cache/pip"]
+
+	image: string | *"python"
+}
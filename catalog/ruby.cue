@@ -0,0 +1,8 @@
+package catalog
+
+// #Ruby keeps installed gems in the bundle volume.
+#Ruby: #Base & {
+	#volumes: ["bundle:/usr/local/bundle"]
+
+	image: string | *"ruby"
+}
@@ -0,0 +1,9 @@
+package catalog
+
+// #Rust keeps cargo's home, with its registry and installed binaries, in a
+// volume.
+#Rust: #Base & {
+	#volumes: ["cargo:/root/.cargo"]
+
+	image: string | *"rust"
+}
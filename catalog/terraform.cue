@@ -0,0 +1,9 @@
+package catalog
+
+// #Terraform keeps downloaded providers in a volume.
+#Terraform: #Base & {
+	#volumes: ["terraform:/root/.terraform.d/plugin-cache"]
+
+	image:       string | *"hashicorp/terraform"
+	environment: [...string] | *["TF_PLUGIN_CACHE_DIR=/root/.terraform.d/plugin-cache"]
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sethpollack/dockerbox/cache"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newCacheCmd(cfg *dockerbox.Config) *cobra.Command {
+	c := cache.New(afero.NewOsFs(), cache.Dir(cfg.RootDir))
+
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "manage the compiled config cache",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "clear",
+			Short: "remove all cached configs",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return c.Clear()
+			},
+		},
+		&cobra.Command{
+			Use:   "status",
+			Short: "show the state of the cache",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				status, err := c.Status()
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("Enabled %t\n", cfg.Cache)
+				fmt.Printf("Dir     %s\n", status.Dir)
+				fmt.Printf("Entries %d\n", status.Entries)
+				fmt.Printf("Size    %d bytes\n", status.Size)
+				if status.Entries > 0 {
+					fmt.Printf("Newest  %s\n", status.Newest.Format(time.RFC3339))
+				}
+
+				return nil
+			},
+		},
+	)
+
+	return cmd
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/catalog"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newCatalogCmd(cfg *dockerbox.Config, root *applet.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "browse and add curated applet definitions",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list the tools in the catalog",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				for _, t := range catalog.Tools() {
+					fmt.Printf("%-10s %s (%s)\n", t.Name, t.Description, strings.Join(t.Applets, ", "))
+				}
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "show <tool>",
+			Short: "print a tool's definition and starter config",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				t, err := catalog.Lookup(args[0])
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("%s\n// starter config\n%s", t.Source(), t.Starter())
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "add <tool>",
+			Short: "write a starter config for a tool to the root dir",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				t, err := catalog.Lookup(args[0])
+				if err != nil {
+					return err
+				}
+
+				for _, name := range t.Applets {
+					if _, ok := root.Applets[name]; ok {
+						return fmt.Errorf("applet %s is already configured", name)
+					}
+				}
+
+				fs := afero.NewOsFs()
+				path := filepath.Join(cfg.RootDir, t.Name+".dbx.cue")
+
+				err = fs.MkdirAll(cfg.RootDir, 0755)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %v", cfg.RootDir, err)
+				}
+
+				f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %v", path, err)
+				}
+				defer f.Close()
+
+				_, err = f.WriteString(t.Starter())
+				if err != nil {
+					return fmt.Errorf("failed to write %s: %v", path, err)
+				}
+
+				fmt.Printf("added %s to %s, run `dockerbox install` to link its applets\n", t.Name, path)
+
+				return nil
+			},
+		},
+	)
+
+	return cmd
+}
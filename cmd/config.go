@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/trust"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCmd(cfg *dockerbox.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect where configs are loaded from",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "sources",
+			Short: "list the config files found, lowest precedence first",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				fs := afero.NewOsFs()
+
+				store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+				if err != nil {
+					return err
+				}
+
+				sources, err := dockerbox.GetConfigurations(fs, cfg, store.Trusts(fs))
+				if err != nil {
+					return err
+				}
+
+				for _, src := range sources {
+					status := "loaded"
+					if src.Origin == dockerbox.Project {
+						if s := store.Status(fs, src.Path); s != trust.Trusted {
+							status = string(s)
+						}
+					}
+
+					fmt.Printf("%-11s %-9s %s\n", src.Origin, status, src.Path)
+				}
+
+				return nil
+			},
+		},
+	)
+
+	return cmd
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/runner"
+	"github.com/spf13/cobra"
+)
+
+func newExplainCmd(cfg *dockerbox.Config, load func(*dockerbox.Config) (*applet.Root, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain <applet> [args...]",
+		Short: "print the commands an applet would run",
+		Args:  cobra.MinimumNArgs(1),
+		// everything after the applet name belongs to the applet
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[0] == "-h" || args[0] == "--help" {
+				return cmd.Help()
+			}
+
+			appletCfg := *cfg
+			appletCfg.EntryPoint = args[0]
+			appletCfg.Args = args[1:]
+
+			root, err := load(&appletCfg)
+			if err != nil {
+				return fmt.Errorf("failed to load applets: %v", err)
+			}
+
+			cmds, err := root.Compile(&appletCfg)
+			if err != nil {
+				return fmt.Errorf("failed to compile applet: %v", err)
+			}
+
+			return runner.Explain(cmd.OutOrStdout(), cmds)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/compose"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newImportCmd(cfg *dockerbox.Config, root *applet.Root) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "convert configs of other tools to applets",
+	}
+
+	var output string
+
+	composeCmd := &cobra.Command{
+		Use:   "compose [file]",
+		Short: "convert docker-compose services to applets",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fs := afero.NewOsFs()
+
+			path, err := composeFile(fs, cfg.WD, args)
+			if err != nil {
+				return err
+			}
+
+			src, err := afero.ReadFile(fs, path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", path, err)
+			}
+
+			result, err := compose.Convert(path, src)
+			if err != nil {
+				return err
+			}
+
+			for _, field := range result.Unmapped {
+				fmt.Fprintf(os.Stderr, "dockerbox: %s has no dockerbox equivalent, skipping it\n", field)
+			}
+
+			if output == "-" {
+				_, err := os.Stdout.Write(result.Config)
+				return err
+			}
+
+			for _, name := range result.Applets {
+				if _, ok := root.Applets[name]; ok {
+					return fmt.Errorf("applet %s is already configured", name)
+				}
+			}
+
+			if output == "" {
+				output = filepath.Join(filepath.Dir(path), "compose.dbx.cue")
+			}
+
+			f, err := fs.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %v", output, err)
+			}
+			defer f.Close()
+
+			_, err = f.Write(result.Config)
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %v", output, err)
+			}
+
+			fmt.Printf("imported %s to %s, run `dockerbox allow` and `dockerbox install` to use them\n", strings.Join(result.Applets, ", "), output)
+
+			return nil
+		},
+	}
+
+	composeCmd.Flags().StringVarP(&output, "output", "o", "", "file to write the config to, - for stdout (default compose.dbx.cue next to the compose file)")
+
+	cmd.AddCommand(composeCmd)
+
+	return cmd
+}
+
+// composeFile returns the compose file given, or the one in dir.
+func composeFile(fs afero.Fs, dir string, args []string) (string, error) {
+	if len(args) > 0 {
+		return filepath.Abs(args[0])
+	}
+
+	for _, name := range compose.Files {
+		path := filepath.Join(dir, name)
+		if ok, _ := afero.Exists(fs, path); ok {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no compose file found in %s", dir)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/lock"
+	"github.com/sethpollack/dockerbox/trust"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newLockCmd(cfg *dockerbox.Config, root *applet.Root) *cobra.Command {
+	var check bool
+
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "pin applet images to digests",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fs := afero.NewOsFs()
+
+			if check {
+				l, err := lock.Load(fs, cfg.LockFile)
+				if err != nil {
+					return err
+				}
+
+				// pulled, so tags moved since they were locked are caught
+				digests, err := root.ResolveDigests(cfg, true)
+				if err != nil {
+					return err
+				}
+
+				return l.Check(digests)
+			}
+
+			digests, err := root.ResolveDigests(cfg, false)
+			if err != nil {
+				return err
+			}
+
+			err = (&lock.Lock{Images: digests}).Write(fs, cfg.LockFile)
+			if err != nil {
+				return err
+			}
+
+			// the digests were just resolved here, so the lock is trusted
+			// like the configs it was made from
+			if cfg.ProjectDir != "" {
+				store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+				if err != nil {
+					return err
+				}
+
+				err = store.Allow(fs, cfg.LockFile)
+				if err != nil {
+					return fmt.Errorf("failed to allow %s: %v", cfg.LockFile, err)
+				}
+
+				err = store.Write(fs, trust.Path(cfg.RootDir))
+				if err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("locked %d images in %s\n", len(digests), cfg.LockFile)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&check, "check", false, "fail if the lock is out of date with the config or the registry")
+
+	return cmd
+}
@@ -6,15 +6,26 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func NewRootCmd(cfg *dockerbox.Config, root *applet.Root) (*cobra.Command, error) {
+// NewRootCmd builds the dockerbox command. load compiles the configs for
+// another invocation, e.g. the applet being explained.
+func NewRootCmd(cfg *dockerbox.Config, root *applet.Root, load func(*dockerbox.Config) (*applet.Root, error)) (*cobra.Command, error) {
 	cmd := &cobra.Command{
 		Use: "dockerbox",
 	}
 
 	cmd.AddCommand(
+		newAllowCmd(cfg),
+		newDenyCmd(cfg),
 		newInstallCmd(cfg, root),
 		newUninstallCmd(cfg, root),
+		newCacheCmd(cfg),
+		newCatalogCmd(cfg, root),
+		newConfigCmd(cfg),
 		newDebugCmd(root),
+		newExplainCmd(cfg, load),
+		newImportCmd(cfg, root),
+		newLockCmd(cfg, root),
+		newTrustCmd(cfg),
 		newVersionCmd(),
 	)
 
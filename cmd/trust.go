@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/lock"
+	"github.com/sethpollack/dockerbox/trust"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newAllowCmd(cfg *dockerbox.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "allow [file...]",
+		Short: "trust config files, by default the untrusted ones found from the working directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fs := afero.NewOsFs()
+
+			store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+			if err != nil {
+				return err
+			}
+
+			files, err := trustFiles(fs, cfg, args, func(f string) bool {
+				return store.Status(fs, f) != trust.Trusted
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, f := range files {
+				err := store.Allow(fs, f)
+				if err != nil {
+					return fmt.Errorf("failed to allow %s: %v", f, err)
+				}
+
+				fmt.Printf("allowed %s\n", f)
+			}
+
+			return store.Write(fs, trust.Path(cfg.RootDir))
+		},
+	}
+}
+
+func newDenyCmd(cfg *dockerbox.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "deny [file...]",
+		Short: "stop trusting config files, by default the ones found from the working directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fs := afero.NewOsFs()
+
+			store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+			if err != nil {
+				return err
+			}
+
+			files, err := trustFiles(fs, cfg, args, func(f string) bool {
+				_, ok := store.Allowed[f]
+				return ok
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, f := range files {
+				if store.Deny(f) {
+					fmt.Printf("denied %s\n", f)
+				}
+			}
+
+			return store.Write(fs, trust.Path(cfg.RootDir))
+		},
+	}
+}
+
+func newTrustCmd(cfg *dockerbox.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trust",
+		Short: "manage trusted config files",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list allowed config files and whether they changed since",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				fs := afero.NewOsFs()
+
+				store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+				if err != nil {
+					return err
+				}
+
+				for _, f := range store.Files() {
+					fmt.Printf("%-9s %s\n", store.Status(fs, f), f)
+				}
+
+				return nil
+			},
+		},
+	)
+
+	return cmd
+}
+
+// trustFiles returns the absolute paths of args, or without args the project
+// configs found from the working directory and the project's lock file that
+// match.
+func trustFiles(fs afero.Fs, cfg *dockerbox.Config, args []string, match func(string) bool) ([]string, error) {
+	files := []string{}
+
+	if len(args) > 0 {
+		for _, arg := range args {
+			f, err := filepath.Abs(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, f)
+		}
+
+		return files, nil
+	}
+
+	// root markers count as if the files were trusted already, which is
+	// what allowing them results in
+	sources, err := dockerbox.GetConfigurations(fs, cfg, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	for _, src := range sources {
+		if src.Origin == dockerbox.Project && match(src.Path) {
+			files = append(files, src.Path)
+		}
+	}
+
+	// the lock is found from all configs, so it's allowed along with them
+	if dir := dockerbox.ProjectDir(sources); dir != "" {
+		f := lock.Path(dir, cfg.RootDir)
+		if ok, _ := afero.Exists(fs, f); ok && match(f) {
+			files = append(files, f)
+		}
+	}
+
+	return files, nil
+}
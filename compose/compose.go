@@ -0,0 +1,815 @@
+// Package compose converts docker-compose files to dockerbox configs.
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+	"gopkg.in/yaml.v3"
+)
+
+// Files are the compose file names looked for when none is given, in the
+// order docker compose prefers them.
+var Files = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Result is a compose file converted to a dockerbox config.
+type Result struct {
+	// Config is the formatted CUE config.
+	Config []byte
+	// Applets are the names of the converted services.
+	Applets []string
+	// Unmapped lists the compose fields without a dockerbox equivalent,
+	// by their path in the compose file.
+	Unmapped []string
+}
+
+var identRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// projectRe matches what compose strips from project names.
+var projectRe = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// appletFields orders the fields of converted applets.
+var appletFields = []string{
+	"applet_name", "name", "image", "image_tag", "build", "entrypoint", "command",
+	"work_dir", "privileged", "detach", "kill", "environment", "env_file", "volumes",
+	"tmpfs", "ports", "networks", "dns", "before_hooks",
+}
+
+// Convert converts the services, volumes and networks of the compose file
+// at path, whose contents are src. Relative paths are resolved against the
+// directory of the compose file.
+func Convert(path string, src []byte) (*Result, error) {
+	var doc any
+	err := yaml.Unmarshal(src, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	file, err := mapping(normalize(doc), path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{
+		dir:      filepath.Dir(path),
+		project:  filepath.Base(filepath.Dir(path)),
+		networks: map[string]bool{},
+		detach:   map[string]bool{},
+		deps:     map[string][]string{},
+	}
+
+	services, err := mapping(file["services"], "services")
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := mapping(file["volumes"], "volumes")
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := mapping(file["networks"], "networks")
+	if err != nil {
+		return nil, err
+	}
+
+	if name, ok := file["name"].(string); ok {
+		c.project = name
+	}
+	c.project = projectRe.ReplaceAllString(strings.ToLower(c.project), "")
+
+	for _, key := range sortedKeys(file) {
+		switch {
+		case key == "services", key == "volumes", key == "networks", key == "version", key == "name":
+		case strings.HasPrefix(key, "x-"):
+			// extensions only hold anchors for the rest of the file
+		default:
+			c.unmapped(key)
+		}
+	}
+
+	// before hooks run one after the other, so services others depend on
+	// have to run in the background like compose starts them
+	for _, name := range sortedKeys(services) {
+		service, err := mapping(services[name], "services."+name)
+		if err != nil {
+			return nil, err
+		}
+
+		deps, err := dependencies(service["depends_on"], "services."+name+".depends_on")
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deps {
+			c.detach[d] = true
+		}
+		c.deps[name] = deps
+	}
+
+	applets := []any{}
+	for _, name := range sortedKeys(services) {
+		service, _ := mapping(services[name], "services."+name)
+
+		applet, err := c.service(name, service)
+		if err != nil {
+			return nil, err
+		}
+
+		applets = append(applets, label(name), applet)
+	}
+
+	f := &ast.File{}
+	ast.AddComment(f, &ast.CommentGroup{
+		Doc:  true,
+		List: []*ast.Comment{{Text: "// Imported from " + path + " by `dockerbox import compose`."}},
+	})
+
+	f.Decls = append(f.Decls, &ast.Field{Label: ast.NewIdent("applets"), Value: ast.NewStruct(applets...)})
+
+	vols, err := c.resources(volumes, "volumes", "#Volume")
+	if err != nil {
+		return nil, err
+	}
+	if len(vols.Elts) > 0 {
+		f.Decls = append(f.Decls, &ast.Field{Label: ast.NewIdent("volumes"), Value: vols})
+	}
+
+	for name := range c.networks {
+		if _, ok := networks[name]; !ok {
+			// compose creates the default network, and any other network
+			// services use, without it being declared
+			networks[name] = nil
+		}
+	}
+
+	nets, err := c.resources(networks, "networks", "#Network")
+	if err != nil {
+		return nil, err
+	}
+	if len(nets.Elts) > 0 {
+		f.Decls = append(f.Decls, &ast.Field{Label: ast.NewIdent("networks"), Value: nets})
+	}
+
+	b, err := format.Node(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format config: %v", err)
+	}
+
+	sort.Strings(c.skipped)
+
+	return &Result{
+		Config:   b,
+		Applets:  sortedKeys(services),
+		Unmapped: c.skipped,
+	}, nil
+}
+
+type converter struct {
+	dir string
+	// project names the containers of services others depend on, like
+	// compose prefixes them.
+	project  string
+	skipped  []string
+	networks map[string]bool
+	detach   map[string]bool
+	deps     map[string][]string
+}
+
+func (c *converter) unmapped(path string) {
+	c.skipped = append(c.skipped, path)
+}
+
+func (c *converter) service(name string, service map[string]any) (ast.Expr, error) {
+	path := "services." + name
+	values := map[string]ast.Expr{"applet_name": ast.NewString(name)}
+	add := func(key string, value ast.Expr) {
+		values[key] = value
+	}
+
+	command, err := words(service["command"], path+".command")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range sortedKeys(service) {
+		value := service[key]
+		p := path + "." + key
+
+		switch key {
+		case "image":
+			image, tag := splitImage(fmt.Sprint(value))
+			add("image", ast.NewString(image))
+			if tag != "latest" {
+				add("image_tag", ast.NewString(tag))
+			}
+		case "build":
+			build, err := c.build(value, p)
+			if err != nil {
+				return nil, err
+			}
+			add("build", build)
+		case "entrypoint":
+			entrypoint, err := words(value, p)
+			if err != nil {
+				return nil, err
+			}
+			if len(entrypoint) == 0 {
+				c.unmapped(p)
+				continue
+			}
+
+			add("entrypoint", ast.NewString(entrypoint[0]))
+			// docker only takes the executable as the entrypoint, its
+			// arguments go in front of the command
+			command = append(entrypoint[1:], command...)
+		case "command":
+			// merged with the entrypoint's arguments below
+		case "environment":
+			env, err := environment(value, p)
+			if err != nil {
+				return nil, err
+			}
+			add("environment", newList(env))
+		case "env_file":
+			files, err := envFiles(value, p)
+			if err != nil {
+				return nil, err
+			}
+			for i, f := range files {
+				files[i] = c.abs(f)
+			}
+			add("env_file", newList(files))
+		case "volumes":
+			volumes, tmpfs, err := c.volumes(value, p)
+			if err != nil {
+				return nil, err
+			}
+			if len(volumes) > 0 {
+				add("volumes", newList(volumes))
+			}
+			if len(tmpfs) > 0 {
+				add("tmpfs", newList(tmpfs))
+			}
+		case "ports":
+			ports, err := ports(value, p)
+			if err != nil {
+				return nil, err
+			}
+			add("ports", newList(ports))
+		case "networks":
+			networks, err := c.serviceNetworks(value, p)
+			if err != nil {
+				return nil, err
+			}
+			add("networks", newList(networks))
+		case "dns":
+			dns, err := stringList(value, p)
+			if err != nil {
+				return nil, err
+			}
+			add("dns", newList(dns))
+		case "container_name":
+			add("name", ast.NewString(fmt.Sprint(value)))
+		case "working_dir":
+			add("work_dir", ast.NewString(fmt.Sprint(value)))
+		case "privileged":
+			privileged, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a boolean", p)
+			}
+			add("privileged", ast.NewBool(privileged))
+		case "depends_on":
+			hooks := []ast.Expr{}
+			for _, d := range c.deps[name] {
+				if m, ok := value.(map[string]any); ok && m[d] != nil {
+					// conditions, the hook just runs first
+					c.unmapped(p + "." + d)
+				}
+
+				if !c.indirect(name, d) {
+					hooks = append(hooks, ref(d))
+				}
+			}
+			add("before_hooks", ast.NewList(hooks...))
+		default:
+			c.unmapped(p)
+		}
+	}
+
+	if len(command) > 0 {
+		add("command", newList(command))
+	}
+
+	// a dependency runs in the background with a fixed name, so the next
+	// run replaces it instead of starting another copy
+	if c.detach[name] {
+		add("detach", ast.NewBool(true))
+		add("kill", ast.NewBool(true))
+		if _, ok := values["name"]; !ok {
+			add("name", ast.NewString(c.project+"-"+name))
+		}
+	}
+
+	fields := []any{}
+	for _, key := range appletFields {
+		if value, ok := values[key]; ok {
+			fields = append(fields, ast.NewIdent(key), value)
+		}
+	}
+
+	return ast.NewBinExpr(token.AND, ast.NewIdent("#Applet"), ast.NewStruct(fields...)), nil
+}
+
+// build converts a build context or build section.
+func (c *converter) build(value any, path string) (ast.Expr, error) {
+	if context, ok := value.(string); ok {
+		return ast.NewStruct(ast.NewIdent("context"), ast.NewString(c.abs(context))), nil
+	}
+
+	build, err := mapping(value, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []any{}
+	for _, key := range sortedKeys(build) {
+		switch key {
+		case "context":
+			fields = append(fields, ast.NewIdent(key), ast.NewString(c.abs(fmt.Sprint(build[key]))))
+		case "dockerfile":
+			// relative to the context, like docker build takes it
+			dockerfile := fmt.Sprint(build[key])
+			if !filepath.IsAbs(dockerfile) {
+				context, _ := build["context"].(string)
+				dockerfile = filepath.Join(c.abs(context), dockerfile)
+			}
+			fields = append(fields, ast.NewIdent(key), ast.NewString(dockerfile))
+		case "target":
+			fields = append(fields, ast.NewIdent(key), ast.NewString(fmt.Sprint(build[key])))
+		case "args":
+			args, err := environment(build[key], path+".args")
+			if err != nil {
+				return nil, err
+			}
+
+			values := []any{}
+			for _, arg := range args {
+				k, v, found := strings.Cut(arg, "=")
+				if !found {
+					// taken from the environment by compose
+					c.unmapped(path + ".args." + k)
+					continue
+				}
+				values = append(values, label(k), ast.NewString(v))
+			}
+			fields = append(fields, ast.NewIdent(key), ast.NewStruct(values...))
+		default:
+			c.unmapped(path + "." + key)
+		}
+	}
+
+	return ast.NewStruct(fields...), nil
+}
+
+// volumes converts the short and long volume syntax to -v volumes and
+// --tmpfs mounts.
+func (c *converter) volumes(value any, path string) ([]string, []string, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s must be a list", path)
+	}
+
+	volumes := []string{}
+	tmpfs := []string{}
+
+	for i, v := range list {
+		p := fmt.Sprintf("%s.%d", path, i)
+
+		if s, ok := v.(string); ok {
+			parts := strings.SplitN(s, ":", 2)
+			if len(parts) == 2 {
+				parts[0] = c.source(parts[0])
+			}
+			volumes = append(volumes, strings.Join(parts, ":"))
+			continue
+		}
+
+		m, err := mapping(v, p)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		target := fmt.Sprint(m["target"])
+		source, _ := m["source"].(string)
+
+		if m["type"] == "tmpfs" {
+			tmpfs = append(tmpfs, target)
+			continue
+		}
+
+		volume := target
+		if source != "" {
+			volume = c.source(source) + ":" + target
+		}
+		if ro, _ := m["read_only"].(bool); ro {
+			volume += ":ro"
+		}
+		volumes = append(volumes, volume)
+
+		for _, key := range sortedKeys(m) {
+			switch key {
+			case "type", "source", "target", "read_only":
+			default:
+				c.unmapped(p + "." + key)
+			}
+		}
+	}
+
+	return volumes, tmpfs, nil
+}
+
+// source resolves relative bind mount sources, named volumes are left as
+// they are.
+func (c *converter) source(source string) string {
+	if strings.HasPrefix(source, ".") {
+		return c.abs(source)
+	}
+
+	return source
+}
+
+func (c *converter) serviceNetworks(value any, path string) ([]string, error) {
+	names := []string{}
+
+	switch v := value.(type) {
+	case []any:
+		for _, n := range v {
+			names = append(names, fmt.Sprint(n))
+		}
+	case map[string]any:
+		for _, name := range sortedKeys(v) {
+			names = append(names, name)
+			if v[name] != nil {
+				// aliases, addresses and priorities
+				c.unmapped(path + "." + name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a list or mapping", path)
+	}
+
+	for _, n := range names {
+		c.networks[n] = true
+	}
+
+	return names, nil
+}
+
+// indirect reports whether dep already runs as a hook of another of the
+// service's dependencies. Hooks of hooks run too, so listing it again would
+// run it twice.
+func (c *converter) indirect(service, dep string) bool {
+	visited := map[string]bool{}
+
+	var reaches func(string) bool
+	reaches = func(name string) bool {
+		if visited[name] {
+			return false
+		}
+		visited[name] = true
+
+		for _, d := range c.deps[name] {
+			if d == dep || reaches(d) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, d := range c.deps[service] {
+		if d != dep && reaches(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dependencies(value any, path string) ([]string, error) {
+	deps := []string{}
+
+	switch v := value.(type) {
+	case nil:
+	case []any:
+		for _, d := range v {
+			deps = append(deps, fmt.Sprint(d))
+		}
+	case map[string]any:
+		deps = append(deps, sortedKeys(v)...)
+	default:
+		return nil, fmt.Errorf("%s must be a list or mapping", path)
+	}
+
+	return deps, nil
+}
+
+// resources converts the top level volumes or networks. Compose prefixes
+// their names with the project, so they are project scoped unless they are
+// named or external.
+func (c *converter) resources(resources map[string]any, path, definition string) (*ast.StructLit, error) {
+	fields := []any{}
+
+	for _, key := range sortedKeys(resources) {
+		p := path + "." + key
+
+		resource := map[string]any{}
+		if resources[key] != nil {
+			var err error
+			resource, err = mapping(resources[key], p)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		name := key
+		scoped := true
+		if n, ok := resource["name"].(string); ok {
+			name, scoped = n, false
+		}
+
+		values := []any{ast.NewIdent("name"), ast.NewString(name)}
+
+		for _, k := range sortedKeys(resource) {
+			switch k {
+			case "name":
+			case "driver":
+				values = append(values, ast.NewIdent("driver"), ast.NewString(fmt.Sprint(resource[k])))
+			case "external":
+				if external, _ := resource[k].(bool); external {
+					scoped = false
+				}
+			default:
+				c.unmapped(p + "." + k)
+			}
+		}
+
+		if scoped {
+			values = append(values, ast.NewIdent("scope"), ast.NewString("project"))
+		}
+
+		fields = append(fields, label(key), ast.NewBinExpr(token.AND, ast.NewIdent(definition), ast.NewStruct(values...)))
+	}
+
+	return ast.NewStruct(fields...), nil
+}
+
+func (c *converter) abs(path string) string {
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	return filepath.Join(c.dir, path)
+}
+
+// environment converts the list or mapping syntax to KEY=VALUE pairs.
+// Variables without a value are passed through from the host.
+func environment(value any, path string) ([]string, error) {
+	switch v := value.(type) {
+	case []any:
+		env := []string{}
+		for _, e := range v {
+			env = append(env, fmt.Sprint(e))
+		}
+		return env, nil
+	case map[string]any:
+		env := []string{}
+		for _, k := range sortedKeys(v) {
+			if v[k] == nil {
+				env = append(env, k)
+				continue
+			}
+			env = append(env, k+"="+fmt.Sprint(v[k]))
+		}
+		return env, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list or mapping", path)
+	}
+}
+
+func envFiles(value any, path string) ([]string, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return stringList(value, path)
+	}
+
+	files := []string{}
+	for _, f := range list {
+		if m, ok := f.(map[string]any); ok {
+			f = m["path"]
+		}
+		files = append(files, fmt.Sprint(f))
+	}
+
+	return files, nil
+}
+
+// ports converts the short and long port syntax to -p ports.
+func ports(value any, path string) ([]string, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list", path)
+	}
+
+	ports := []string{}
+	for _, p := range list {
+		m, ok := p.(map[string]any)
+		if !ok {
+			ports = append(ports, fmt.Sprint(p))
+			continue
+		}
+
+		port := fmt.Sprint(m["target"])
+		if published, ok := m["published"]; ok {
+			port = fmt.Sprint(published) + ":" + port
+			if ip, ok := m["host_ip"]; ok {
+				port = fmt.Sprint(ip) + ":" + port
+			}
+		}
+		if protocol, ok := m["protocol"]; ok {
+			port += "/" + fmt.Sprint(protocol)
+		}
+		ports = append(ports, port)
+	}
+
+	return ports, nil
+}
+
+func stringList(value any, path string) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := []string{}
+		for _, s := range v {
+			list = append(list, fmt.Sprint(s))
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("%s must be a string or list", path)
+	}
+}
+
+// words converts a command in exec form, or in shell form split like a
+// shell would without expanding anything.
+func words(value any, path string) ([]string, error) {
+	if s, ok := value.(string); ok {
+		return splitWords(s)
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	return stringList(value, path)
+}
+
+func splitWords(s string) ([]string, error) {
+	words := []string{}
+	word := &strings.Builder{}
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// splitImage splits the tag off an image reference. Images referenced by
+// digest have no tag.
+func splitImage(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, "latest"
+	}
+
+	return image[:i], image[i+1:]
+}
+
+// normalize turns the maps yaml decodes merge keys into to string keyed
+// maps like the rest.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case map[any]any:
+		m := map[string]any{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+func mapping(value any, path string) (map[string]any, error) {
+	if value == nil {
+		return map[string]any{}, nil
+	}
+
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a mapping", path)
+	}
+
+	return m, nil
+}
+
+func label(name string) ast.Label {
+	if identRe.MatchString(name) {
+		return ast.NewIdent(name)
+	}
+
+	return ast.NewString(name)
+}
+
+// ref refers to the applet converted from a service.
+func ref(name string) ast.Expr {
+	if identRe.MatchString(name) {
+		return ast.NewSel(ast.NewIdent("applets"), name)
+	}
+
+	return &ast.IndexExpr{X: ast.NewIdent("applets"), Index: ast.NewString(name)}
+}
+
+func newList(list []string) ast.Expr {
+	exprs := make([]ast.Expr, len(list))
+	for i, s := range list {
+		exprs[i] = ast.NewString(s)
+	}
+
+	return ast.NewList(exprs...)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,257 @@
+package compose
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sethpollack/dockerbox/cue"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	tt := []struct {
+		name     string
+		compose  string
+		config   string
+		unmapped []string
+		err      error
+	}{
+		{
+			name: "converts services",
+			compose: `
+services:
+  web:
+    image: nginx:1.25
+    entrypoint: ["nginx", "-g"]
+    command: daemon off;
+    environment:
+      FOO: bar
+      PASSED:
+    env_file: .env
+    volumes:
+      - ./html:/usr/share/nginx/html:ro
+      - type: volume
+        source: cache
+        target: /cache
+        read_only: true
+    ports:
+      - "8080:80"
+      - target: 443
+        published: 8443
+        protocol: tcp
+    networks: [front]
+    dns: 1.1.1.1
+    working_dir: /srv
+    privileged: true
+    healthcheck:
+      test: ["CMD", "true"]
+volumes:
+  cache:
+`,
+			config: "// Imported from /src/docker-compose.yml by `dockerbox import compose`.\n" +
+				`applets: {
+	web: #Applet & {
+		applet_name: "web"
+		image:       "nginx"
+		image_tag:   "1.25"
+		entrypoint:  "nginx"
+		command: ["-g", "daemon", "off;"]
+		work_dir:   "/srv"
+		privileged: true
+		environment: ["FOO=bar", "PASSED"]
+		env_file: ["/src/.env"]
+		volumes: ["/src/html:/usr/share/nginx/html:ro", "cache:/cache:ro"]
+		ports: ["8080:80", "8443:443/tcp"]
+		networks: ["front"]
+		dns: ["1.1.1.1"]
+	}
+}
+volumes: {
+	cache: #Volume & {
+		name:  "cache"
+		scope: "project"
+	}
+}
+networks: {
+	front: #Network & {
+		name:  "front"
+		scope: "project"
+	}
+}
+`,
+			unmapped: []string{"services.web.healthcheck"},
+		},
+		{
+			name: "converts dependencies to before hooks",
+			compose: `
+name: My App
+services:
+  app:
+    image: app
+    depends_on: [db, cache]
+  cache:
+    image: redis
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: postgres@sha256:abc
+    container_name: pg
+  my-job:
+    image: job
+    depends_on: [app]
+`,
+			config: "// Imported from /src/docker-compose.yml by `dockerbox import compose`.\n" +
+				`applets: {
+	app: #Applet & {
+		applet_name: "app"
+		name:        "myapp-app"
+		image:       "app"
+		detach:      true
+		kill:        true
+		before_hooks: [applets.cache]
+	}
+	cache: #Applet & {
+		applet_name: "cache"
+		name:        "myapp-cache"
+		image:       "redis"
+		detach:      true
+		kill:        true
+		before_hooks: [applets.db]
+	}
+	db: #Applet & {
+		applet_name: "db"
+		name:        "pg"
+		image:       "postgres@sha256:abc"
+		image_tag:   ""
+		detach:      true
+		kill:        true
+	}
+	"my-job": #Applet & {
+		applet_name: "my-job"
+		image:       "job"
+		before_hooks: [applets.app]
+	}
+}
+`,
+			unmapped: []string{"services.cache.depends_on.db"},
+		},
+		{
+			name: "converts builds and named resources",
+			compose: `
+x-env: &env
+  environment: [A=1]
+services:
+  app:
+    <<: *env
+    build:
+      context: ./app
+      dockerfile: Dockerfile.dev
+      args:
+        VERSION: 1
+    networks:
+      default:
+      back:
+        aliases: [api]
+volumes:
+  data:
+    name: shared-data
+networks:
+  back:
+    external: true
+configs:
+  app:
+    file: ./app.conf
+`,
+			config: "// Imported from /src/docker-compose.yml by `dockerbox import compose`.\n" +
+				`applets: {
+	app: #Applet & {
+		applet_name: "app"
+		build: {
+			args: {
+				VERSION: "1"
+			}
+			context:    "/src/app"
+			dockerfile: "/src/app/Dockerfile.dev"
+		}
+		environment: ["A=1"]
+		networks: ["back", "default"]
+	}
+}
+volumes: {
+	data: #Volume & {
+		name: "shared-data"
+	}
+}
+networks: {
+	back: #Network & {
+		name: "back"
+	}
+	default: #Network & {
+		name:  "default"
+		scope: "project"
+	}
+}
+`,
+			unmapped: []string{"configs", "services.app.networks.back"},
+		},
+		{
+			name:    "fails on invalid services",
+			compose: "services: [web]",
+			err:     errors.New("services must be a mapping"),
+		},
+		{
+			name:    "fails on invalid yaml",
+			compose: "services: {",
+			err:     errors.New("failed to parse /src/docker-compose.yml: yaml: line 1: did not find expected node content"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Convert("/src/docker-compose.yml", []byte(tc.compose))
+			assert.Equal(t, tc.err, err)
+			if tc.err != nil {
+				return
+			}
+
+			assert.Equal(t, tc.config, string(result.Config))
+			assert.Equal(t, tc.unmapped, result.Unmapped)
+
+			fs := afero.NewMemMapFs()
+			err = afero.WriteFile(fs, "/src/compose.dbx.cue", result.Config, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := cue.New(fs, []string{"/src/compose.dbx.cue"}, cue.Options{})
+			assert.Nil(t, err)
+			for _, name := range result.Applets {
+				assert.Contains(t, root.Applets, name)
+			}
+		})
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tt := []struct {
+		command  string
+		expected []string
+		err      error
+	}{
+		{command: "rails server -b 0.0.0.0", expected: []string{"rails", "server", "-b", "0.0.0.0"}},
+		{command: `sh -c 'echo "$FOO"'`, expected: []string{"sh", "-c", `echo "$FOO"`}},
+		{command: `echo "a b" c\ d`, expected: []string{"echo", "a b", "c d"}},
+		{command: `echo ''`, expected: []string{"echo", ""}},
+		{command: `echo "a`, err: errors.New(`unterminated quote in "echo \"a"`)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.command, func(t *testing.T) {
+			words, err := splitWords(tc.command)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, words)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package cue
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"cuelang.org/go/cue/ast"
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/cache"
+	"github.com/sethpollack/dockerbox/catalog"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/version"
+	"github.com/spf13/afero"
+)
+
+// NewCached is like New, but reuses a previous result from c as long as
+// neither the files nor the environment variables they refer to changed.
+// Configs referring to the invocation aren't cached, as nearly every
+// invocation would result in an entry of its own.
+func NewCached(fs afero.Fs, files []string, opts Options, c *cache.Cache) (*applet.Root, error) {
+	key, ok, err := cacheKey(fs, files, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &applet.Root{}
+	if ok && c.Get(key, root) {
+		return root, nil
+	}
+
+	root, err = New(fs, files, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		// the cache is an optimization, failing to write it is not fatal
+		_ = c.Put(key, root)
+	}
+
+	return root, nil
+}
+
+// cacheFormat is bumped whenever the cached applet.Root changes layout.
+const cacheFormat = 1
+
+// cacheKey hashes everything a compilation depends on. Files that fail to
+// parse can't be cached, the error is left for the compilation to report.
+// Neither can files referring to the invocation.
+func cacheKey(fs afero.Fs, files []string, opts Options) (string, bool, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%x\x00", cacheFormat, version.Version, version.Commit, sha256.Sum256(schema))
+	// dev builds don't set a version, a rebuilt binary is told apart by its
+	// size and modification time
+	fmt.Fprintf(h, "%s\x00", executableStamp())
+	fmt.Fprintf(h, "%t\x00", opts.Layered)
+	fmt.Fprintf(h, "%+v\x00", opts.Host)
+
+	catalogFiles := catalog.Files()
+	for _, name := range sortedKeys(catalogFiles) {
+		fmt.Fprintf(h, "%s\x00%x\x00", name, sha256.Sum256(catalogFiles[name]))
+	}
+
+	names := map[string]bool{}
+	all := false
+
+	for _, filename := range files {
+		bytes, err := afero.ReadFile(fs, filename)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+
+		fmt.Fprintf(h, "%s\x00%x\x00", filename, sha256.Sum256(bytes))
+
+		f, err := dockerbox.ParseConfig(filename, bytes)
+		if err != nil || usesInvocation(f) {
+			return "", false, nil
+		}
+
+		refs, allRefs := environRefs(f)
+		all = all || allRefs
+		for _, n := range refs {
+			names[n] = true
+		}
+	}
+
+	env := []string{}
+	if all {
+		env = os.Environ()
+	} else {
+		for n := range names {
+			if v, ok := os.LookupEnv(n); ok {
+				env = append(env, n+"="+v)
+			}
+		}
+	}
+	sort.Strings(env)
+
+	for _, e := range env {
+		fmt.Fprintf(h, "%s\x00", e)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), true, nil
+}
+
+func executableStamp() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d:%d", exe, info.Size(), info.ModTime().UnixNano())
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// environRefs returns the environment variables a file refers to with
+// `environ.NAME` or `environ["NAME"]`. Any other use of environ, such as
+// iterating over it, makes the file depend on the whole environment.
+func environRefs(f *ast.File) ([]string, bool) {
+	names := []string{}
+	all := false
+
+	ast.Walk(f, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if isEnviron(x.X) {
+				name, _, err := ast.LabelName(x.Sel)
+				if err != nil {
+					all = true
+				}
+				names = append(names, name)
+				return false
+			}
+		case *ast.IndexExpr:
+			if isEnviron(x.X) {
+				lit, ok := x.Index.(*ast.BasicLit)
+				if !ok {
+					all = true
+					return false
+				}
+
+				name, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					all = true
+				}
+				names = append(names, name)
+				return false
+			}
+		case *ast.Ident:
+			if isEnviron(x) {
+				all = true
+			}
+		}
+
+		return true
+	}, nil)
+
+	return names, all
+}
+
+// usesInvocation reports whether a file refers to `invocation`.
+func usesInvocation(f *ast.File) bool {
+	uses := false
+
+	ast.Walk(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "invocation" {
+			uses = true
+		}
+
+		return !uses
+	}, nil)
+
+	return uses
+}
+
+func isEnviron(n ast.Node) bool {
+	id, ok := n.(*ast.Ident)
+	return ok && id.Name == "environ"
+}
@@ -0,0 +1,116 @@
+package cue
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue/parser"
+	"github.com/sethpollack/dockerbox/cache"
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironRefs(t *testing.T) {
+	tt := []struct {
+		name  string
+		data  string
+		names []string
+		all   bool
+	}{
+		{
+			name:  "selectors and indexes",
+			data:  `a: environ.FOO, b: environ["BAR"], c: "\(environ.BAZ)"`,
+			names: []string{"FOO", "BAR", "BAZ"},
+		},
+		{
+			name:  "comprehensions",
+			data:  `a: [ for k, v in environ { k } ]`,
+			names: []string{},
+			all:   true,
+		},
+		{
+			name:  "no references",
+			data:  `a: "environ"`,
+			names: []string{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := parser.ParseFile("test.dbx.cue", tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names, all := environRefs(f)
+			assert.Equal(t, tc.names, names)
+			assert.Equal(t, tc.all, all)
+		})
+	}
+}
+
+func TestNewCached(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := cache.New(fs, "/root/cache")
+	files := []string{"/root/test.dbx.cue"}
+
+	err := afero.WriteFile(fs, files[0], []byte(`applets: test: { applet_name: environ.DBX_NAME }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DBX_NAME", "foo")
+	t.Setenv("DBX_OTHER", "foo")
+
+	root, err := NewCached(fs, files, Options{}, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", root.Applets["test"].AppletName)
+
+	// unreferenced variables don't invalidate the cache
+	t.Setenv("DBX_OTHER", "bar")
+
+	status, _ := c.Status()
+	_, err = NewCached(fs, files, Options{}, c)
+	assert.Nil(t, err)
+	after, _ := c.Status()
+	assert.Equal(t, status.Entries, after.Entries)
+
+	t.Setenv("DBX_NAME", "bar")
+
+	root, err = NewCached(fs, files, Options{}, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", root.Applets["test"].AppletName)
+
+	err = afero.WriteFile(fs, files[0], []byte(`applets: test: { applet_name: "baz" }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err = NewCached(fs, files, Options{}, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "baz", root.Applets["test"].AppletName)
+}
+
+func TestNewCachedInvocation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := cache.New(fs, "/root/cache")
+	files := []string{"/root/test.dbx.cue"}
+
+	err := afero.WriteFile(fs, files[0], []byte(`applets: test: { applet_name: invocation.subcommand }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := NewCached(fs, files, Options{Invocation: dockerbox.Invocation{Subcommand: "foo"}}, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", root.Applets["test"].AppletName)
+
+	root, err = NewCached(fs, files, Options{Invocation: dockerbox.Invocation{Subcommand: "bar"}}, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", root.Applets["test"].AppletName)
+
+	// a cache entry per invocation would rarely be used again
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status.Entries)
+}
@@ -4,6 +4,7 @@ import (
 	_ "embed"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"cuelang.org/go/cue"
@@ -11,23 +12,60 @@ import (
 	"cuelang.org/go/cue/errors"
 	"cuelang.org/go/cue/load"
 	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/catalog"
+	"github.com/sethpollack/dockerbox/dockerbox"
 	"github.com/spf13/afero"
 )
 
 //go:embed schema.cue
 var schema []byte
 
+// catalogRoot is a virtual module holding the catalog, so configs can
+// import it without a cue.mod of their own.
+const catalogRoot = "/dockerbox.dev"
+
+func catalogOverlay() map[string]load.Source {
+	overlay := map[string]load.Source{
+		filepath.Join(catalogRoot, "cue.mod", "module.cue"): load.FromString(`module: "dockerbox.dev/configs"`),
+	}
+
+	for name, bytes := range catalog.Files() {
+		overlay[filepath.Join(catalogRoot, "cue.mod", "pkg", catalog.ImportPath, name)] = load.FromBytes(bytes)
+	}
+
+	return overlay
+}
+
 type Cue struct {
-	ctx   *cue.Context
-	fs    afero.Fs
-	files []string
+	ctx        *cue.Context
+	fs         afero.Fs
+	files      []string
+	invocation dockerbox.Invocation
+	host       dockerbox.Host
+	layered    bool
 }
 
-func New(fs afero.Fs, files []string) (*applet.Root, error) {
+// Options control how configs are compiled.
+type Options struct {
+	// Invocation is exposed to the configs as `invocation`, so they have
+	// to be compiled once per invocation.
+	Invocation dockerbox.Invocation
+	// Host is exposed to the configs as `host`.
+	Host dockerbox.Host
+	// Layered lets later files override the concrete values of earlier
+	// ones instead of unifying with them.
+	Layered bool
+}
+
+// New compiles the configs in files, lowest precedence first.
+func New(fs afero.Fs, files []string, opts Options) (*applet.Root, error) {
 	c := &Cue{
-		fs:    fs,
-		files: files,
-		ctx:   cuecontext.New(),
+		fs:         fs,
+		files:      files,
+		ctx:        cuecontext.New(),
+		invocation: opts.Invocation,
+		host:       opts.Host,
+		layered:    opts.Layered,
 	}
 
 	return c.Compile()
@@ -39,7 +77,21 @@ func (c *Cue) Compile() (*applet.Root, error) {
 		return nil, err
 	}
 
-	value := c.Unify(values)
+	if len(values) == 0 {
+		return &applet.Root{}, nil
+	}
+
+	var value cue.Value
+	var builds map[string]string
+	if c.layered {
+		value, builds, err = c.layers(values)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		value = c.Unify(values)
+	}
+
 	if value.Err() != nil {
 		return nil, fmt.Errorf("failed to unify cue: %s", errors.Details(value.Err(), nil))
 	}
@@ -59,9 +111,55 @@ func (c *Cue) Compile() (*applet.Root, error) {
 		return nil, fmt.Errorf("failed to decode cue: %v", errors.Details(err, nil))
 	}
 
+	if c.layered {
+		resolveLayeredBuilds(root, builds)
+	} else {
+		c.resolveBuilds(value, root)
+	}
+
 	return root, nil
 }
 
+// resolveBuilds makes the paths in build blocks absolute, relative to the
+// directory of the config file that set them. Defaults from the schema are
+// relative to the file defining the build block.
+func (c *Cue) resolveBuilds(value cue.Value, root *applet.Root) {
+	configs := map[string]bool{}
+	for _, f := range c.files {
+		configs[f] = true
+	}
+
+	for name, a := range root.Applets {
+		if a.Build == nil {
+			continue
+		}
+
+		build := cue.MakePath(cue.Str("applets"), cue.Str(name), cue.Str("build"))
+
+		dir := func(path cue.Path) string {
+			for _, p := range []cue.Path{path, build} {
+				filename := value.LookupPath(p).Pos().Filename()
+				if configs[filename] {
+					return filepath.Dir(filename)
+				}
+			}
+
+			return ""
+		}
+
+		resolve := func(field, path string) string {
+			if path == "" || filepath.IsAbs(path) {
+				return path
+			}
+
+			return filepath.Join(dir(cue.MakePath(append(build.Selectors(), cue.Str(field))...)), path)
+		}
+
+		a.Build.Context = resolve("context", a.Build.Context)
+		a.Build.Dockerfile = resolve("dockerfile", a.Build.Dockerfile)
+	}
+}
+
 func (c *Cue) Unify(values []cue.Value) cue.Value {
 	value := values[0]
 
@@ -86,13 +184,29 @@ func (c *Cue) AddEnvs(v cue.Value) cue.Value {
 	)
 }
 
+func (c *Cue) AddHost(v cue.Value) cue.Value {
+	return v.Unify(
+		c.ctx.Encode(map[string]any{
+			"host": c.host,
+		}),
+	)
+}
+
+func (c *Cue) AddInvocation(v cue.Value) cue.Value {
+	return v.Unify(
+		c.ctx.Encode(map[string]any{
+			"invocation": c.invocation,
+		}),
+	)
+}
+
 func (c *Cue) CompileSchema() cue.Value {
 	value := c.ctx.CompileBytes(
 		schema,
 		cue.Filename("schema.cue"),
 	)
 
-	return c.AddEnvs(value)
+	return c.AddInvocation(c.AddHost(c.AddEnvs(value)))
 }
 
 func (c *Cue) Values() ([]cue.Value, error) {
@@ -103,21 +217,64 @@ func (c *Cue) Values() ([]cue.Value, error) {
 		return nil, fmt.Errorf("failed to compile schema: %v", errors.Details(schema.Err(), nil))
 	}
 
+	if len(c.files) == 0 {
+		return values, nil
+	}
+
 	cfg := &load.Config{
-		Overlay: map[string]load.Source{},
+		ModuleRoot: catalogRoot,
+		Overlay:    catalogOverlay(),
 	}
 
+	// CUE files are loaded as one instance, unless every file is its own
+	// layer
+	configs := []string{}
+
 	for _, filename := range c.files {
 		bytes, err := afero.ReadFile(c.fs, filename)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read %s: %v", filename, err)
 		}
 
+		if dockerbox.IsData(filename) {
+			value, err := c.data(schema, filename, bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+			continue
+		}
+
 		cfg.Overlay[filename] = load.FromBytes(bytes)
+
+		if !c.layered {
+			configs = append(configs, filename)
+			continue
+		}
+
+		layer, err := c.instances(schema, cfg, []string{filename})
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, layer...)
+	}
+
+	if len(configs) > 0 {
+		instances, err := c.instances(schema, cfg, configs)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, instances...)
 	}
 
-	bis := load.Instances(c.files, cfg)
-	for _, bi := range bis {
+	return values, nil
+}
+
+func (c *Cue) instances(schema cue.Value, cfg *load.Config, files []string) ([]cue.Value, error) {
+	values := []cue.Value{}
+
+	for _, bi := range load.Instances(files, cfg) {
 		if bi.Err != nil {
 			return nil, fmt.Errorf("failed to load configs: %v", bi.Err)
 		}
@@ -132,3 +289,37 @@ func (c *Cue) Values() ([]cue.Value, error) {
 
 	return values, nil
 }
+
+// data builds a YAML or JSON config. Data can't refer to the schema, so its
+// top level fields are unified with the schema's instead.
+func (c *Cue) data(schema cue.Value, filename string, bytes []byte) (cue.Value, error) {
+	f, err := dockerbox.ParseConfig(filename, bytes)
+	if err != nil {
+		return cue.Value{}, fmt.Errorf("failed to parse %s: %v", filename, errors.Details(err, nil))
+	}
+
+	value := c.constrain(schema, c.ctx.BuildFile(f))
+	if value.Err() != nil {
+		return cue.Value{}, fmt.Errorf("failed to build %s: %v", filename, errors.Details(value.Err(), nil))
+	}
+
+	return value, nil
+}
+
+// constrain unifies the top level fields of value with the schema's, so
+// they are validated and get their defaults.
+func (c *Cue) constrain(schema, value cue.Value) cue.Value {
+	iter, err := value.Fields()
+	if err != nil {
+		return value
+	}
+
+	for iter.Next() {
+		path := cue.MakePath(cue.Str(iter.Label()))
+		if s := schema.LookupPath(path); s.Exists() {
+			value = value.FillPath(path, s)
+		}
+	}
+
+	return value
+}
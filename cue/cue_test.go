@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/catalog"
+	"github.com/sethpollack/dockerbox/dockerbox"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
@@ -20,6 +22,7 @@ func TestNew(t *testing.T) {
 	tt := []struct {
 		name     string
 		envs     map[string]string
+		host     dockerbox.Host
 		configs  []configs
 		files    []string
 		expected *applet.Root
@@ -52,6 +55,106 @@ func TestNew(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "compiles resource limits",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: test: #Applet & {
+							applet_name: "test"
+							image: "test"
+							memory: "512m"
+							pids_limit: 100
+							ulimits: nofile: {soft: 1024, hard: 2048}
+						}
+					`,
+				},
+			},
+			files: []string{"/root/test.dbx.cue"},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						Tag:         "latest",
+						Interactive: true,
+						RM:          true,
+						TTY:         true,
+						Memory:      "512m",
+						PidsLimit:   100,
+						Ulimits: applet.Ulimits{
+							"nofile": {Soft: 1024, Hard: 2048},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "exposes the host user",
+			host: dockerbox.Host{UID: 1000, GID: 100, Groups: []int{}, User: "me"},
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: test: #Applet & {
+							applet_name: "test"
+							image: "test"
+							user: "\(host.uid):\(host.gid)"
+						}
+					`,
+				},
+			},
+			files: []string{"/root/test.dbx.cue"},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						Tag:         "latest",
+						Interactive: true,
+						RM:          true,
+						TTY:         true,
+						User:        "1000:100",
+					},
+				},
+			},
+		},
+		{
+			name: "resolves build paths",
+			configs: []configs{
+				{
+					path: "/root/tools/test.dbx.cue",
+					data: `
+						applets: test: #Applet & {
+							applet_name: "test"
+							build: {
+								dockerfile: "docker/Dockerfile"
+								args: VERSION: "1"
+							}
+						}
+					`,
+				},
+			},
+			files: []string{"/root/tools/test.dbx.cue"},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "dockerbox/test",
+						Tag:         "latest",
+						Interactive: true,
+						RM:          true,
+						TTY:         true,
+						Build: &applet.Build{
+							Context:    "/root/tools",
+							Dockerfile: "/root/tools/docker/Dockerfile",
+							Args:       map[string]string{"VERSION": "1"},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "fails to compile invalid configs",
 			configs: []configs{
@@ -182,6 +285,79 @@ func TestNew(t *testing.T) {
 			files: []string{"/root/test.dbx.cue"},
 			err:   errors.New("failed to validate cue: applets.test.image: incomplete value string:\n    schema.cue:6:10\n"),
 		},
+		{
+			name: "compiles yaml and json configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.yaml",
+					data: "applets:\n" +
+						"  test:\n" +
+						"    applet_name: test\n" +
+						"    image: test\n" +
+						"    environment: [FOO=bar]\n",
+				},
+				{
+					path: "/root/test.dbx.json",
+					data: `{"applets": {"test": {"image_tag": "v1"}}}`,
+				},
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: test: rm: false
+					`,
+				},
+			},
+			files: []string{"/root/test.dbx.yaml", "/root/test.dbx.json", "/root/test.dbx.cue"},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"test": {
+						AppletName:  "test",
+						Image:       "test",
+						Tag:         "v1",
+						Interactive: true,
+						RM:          false,
+						TTY:         true,
+						Env:         []string{"FOO=bar"},
+					},
+				},
+			},
+		},
+		{
+			name: "points to the line of invalid yaml configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.yaml",
+					data: "applets:\n" +
+						"  test:\n" +
+						"    applet_name: test\n" +
+						"    image: 3\n",
+				},
+			},
+			files: []string{"/root/test.dbx.yaml"},
+			err:   errors.New("failed to build /root/test.dbx.yaml: applets.test.image: conflicting values 3 and string (mismatched types int and string):\n    /root/test.dbx.yaml:4:13\n    schema.cue:6:10\n    schema.cue:120:20\n"),
+		},
+		{
+			name: "points to the line of invalid json configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.json",
+					data: "{\n  \"applets\": {\n    \"test\": {\n      \"applet_name\": 1\n    }\n  }\n}\n",
+				},
+			},
+			files: []string{"/root/test.dbx.json"},
+			err:   errors.New("failed to build /root/test.dbx.json: applets.test.applet_name: conflicting values 1 and string (mismatched types int and string):\n    /root/test.dbx.json:4:22\n    schema.cue:2:16\n    schema.cue:120:20\n"),
+		},
+		{
+			name: "fails to parse invalid yaml configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.yaml",
+					data: "applets:\n  test: [\n",
+				},
+			},
+			files: []string{"/root/test.dbx.yaml"},
+			err:   errors.New("failed to parse /root/test.dbx.yaml: /root/test.dbx.yaml:2: did not find expected node content\n"),
+		},
 	}
 
 	for _, tc := range tt {
@@ -207,9 +383,214 @@ func TestNew(t *testing.T) {
 				}
 			}
 
-			actual, err := New(fs, tc.files)
+			actual, err := New(fs, tc.files, Options{Host: tc.host})
 			assert.Equal(t, tc.err, err)
 			assert.Equal(t, tc.expected, actual)
 		})
 	}
 }
+
+func TestNewInvocation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := []string{"/root/test.dbx.cue"}
+
+	err := afero.WriteFile(fs, files[0], []byte(`
+		applets: kubectl: #Applet & {
+			applet_name: "kubectl"
+			image: "bitnami/kubectl"
+			if invocation.subcommand == "proxy" {
+				ports: ["8001:8001"]
+			}
+		}
+	`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := New(fs, files, Options{Invocation: dockerbox.Invocation{Applet: "kubectl", Args: []string{"proxy"}, Subcommand: "proxy"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"8001:8001"}, root.Applets["kubectl"].Ports)
+
+	root, err = New(fs, files, Options{Invocation: dockerbox.Invocation{Applet: "kubectl", Args: []string{"get", "pods"}, Subcommand: "get"}})
+	assert.Nil(t, err)
+	assert.Nil(t, root.Applets["kubectl"].Ports)
+}
+
+func TestNewCatalog(t *testing.T) {
+	t.Setenv("PWD", "/src")
+	t.Setenv("HOME", "/home/me")
+
+	for _, tool := range catalog.Tools() {
+		t.Run(tool.Name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			files := []string{"/root/" + tool.Name + ".dbx.cue"}
+
+			err := afero.WriteFile(fs, files[0], []byte(tool.Starter()), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := New(fs, files, Options{})
+			assert.Nil(t, err)
+
+			for _, name := range tool.Applets {
+				a := root.Applets[name]
+				assert.Equal(t, name, a.AppletName)
+				assert.Equal(t, name, a.Entrypoint)
+				assert.Equal(t, "/src", a.WorkDir)
+				assert.Contains(t, a.Volumes, "/src:/src")
+				assert.True(t, a.RM)
+			}
+
+			for _, name := range tool.Volumes {
+				assert.Equal(t, name, root.Volumes[name].Name)
+			}
+		})
+	}
+}
+
+func TestNewLayered(t *testing.T) {
+	tt := []struct {
+		name     string
+		configs  []configs
+		expected *applet.Root
+		err      error
+	}{
+		{
+			name: "later files override earlier ones",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: ruby: #Applet & {
+							applet_name: "ruby"
+							image: "ruby"
+							image_tag: "3.1"
+							rm: false
+							environment: ["A=1"]
+							volumes: ["/a:/a"]
+						}
+					`,
+				},
+				{
+					path: "/src/test.dbx.cue",
+					data: `
+						applets: ruby: #Applet & {
+							applet_name: "ruby"
+							image_tag: "3.2"
+							cpu_shares: 512
+							environment: ["B=2"]
+							volumes: ["/b:/b"] @dbx(append)
+						}
+					`,
+				},
+			},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"ruby": {
+						AppletName:  "ruby",
+						Image:       "ruby",
+						Tag:         "3.2",
+						Interactive: true,
+						TTY:         true,
+						RM:          false,
+						CPUShares:   512,
+						Env:         []string{"B=2"},
+						Volumes:     []string{"/a:/a", "/b:/b"},
+					},
+				},
+			},
+		},
+		{
+			name: "applies the schema to the merged configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: ruby: {
+							applet_name: "ruby"
+							image: "ruby"
+						}
+					`,
+				},
+				{
+					path: "/src/test.dbx.cue",
+					data: `
+						applets: ruby: build: dockerfile: "Dockerfile.dev"
+					`,
+				},
+			},
+			expected: &applet.Root{
+				Applets: map[string]applet.Applet{
+					"ruby": {
+						AppletName:  "ruby",
+						Image:       "ruby",
+						Tag:         "latest",
+						Interactive: true,
+						TTY:         true,
+						RM:          true,
+						Build: &applet.Build{
+							Context:    "/src",
+							Dockerfile: "/src/Dockerfile.dev",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "validates the merged configs",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: ruby: {
+							applet_name: "ruby"
+							image: "ruby"
+						}
+					`,
+				},
+				{
+					path: "/src/test.dbx.cue",
+					data: `
+						applets: ruby: rm: "yes"
+					`,
+				},
+			},
+			err: errors.New("failed to unify cue: applets.ruby.rm: 2 errors in empty disjunction:\napplets.ruby.rm: conflicting values \"yes\" and bool (mismatched types string and bool):\n    schema.cue:33:7\n    schema.cue:120:20\napplets.ruby.rm: conflicting values \"yes\" and true (mismatched types string and bool):\n    schema.cue:33:15\n    schema.cue:120:20\n"),
+		},
+		{
+			name: "rejects unknown annotations",
+			configs: []configs{
+				{
+					path: "/root/test.dbx.cue",
+					data: `
+						applets: ruby: volumes: ["/a:/a"] @dbx(prepend)
+					`,
+				},
+			},
+			err: errors.New("failed to merge /root/test.dbx.cue: applets.ruby.volumes: invalid @dbx(prepend): must be append or replace"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			files := []string{}
+
+			for _, config := range tc.configs {
+				err := afero.WriteFile(fs, config.path, []byte(config.data), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				files = append(files, config.path)
+			}
+
+			actual, err := New(fs, files, Options{Layered: true})
+			assert.Equal(t, tc.err, err)
+			if tc.err == nil {
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package cue
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"github.com/sethpollack/dockerbox/applet"
+)
+
+// layers merges the configs one file at a time, in precedence order. Instead
+// of unifying with earlier files, the concrete values of a later file
+// override theirs. Defaults only fill in values no earlier file set, and
+// lists are replaced unless the field is marked @dbx(append).
+//
+// The merged configs are unified with the schema, so they are validated and
+// get their defaults like data configs do.
+func (c *Cue) layers(values []cue.Value) (cue.Value, map[string]string, error) {
+	merged := map[string]any{}
+	// builds holds the directory of the last file setting each applet's
+	// build block, which relative build paths left to the schema are
+	// resolved against.
+	builds := map[string]string{}
+
+	for i, v := range values {
+		l := &layer{dir: filepath.Dir(c.files[i]), builds: builds}
+
+		err := l.merge(merged, v, nil)
+		if err != nil {
+			return cue.Value{}, nil, fmt.Errorf("failed to merge %s: %v", c.files[i], err)
+		}
+	}
+
+	return c.constrain(c.CompileSchema(), c.ctx.Encode(merged)), builds, nil
+}
+
+type layer struct {
+	dir    string
+	builds map[string]string
+}
+
+func (l *layer) merge(dst map[string]any, v cue.Value, path []string) error {
+	iter, err := v.Fields()
+	if err != nil {
+		return fmt.Errorf("%s", errors.Details(err, nil))
+	}
+
+	for iter.Next() {
+		label := iter.Label()
+		field := iter.Value()
+		fieldPath := append(append([]string{}, path...), label)
+
+		mode, err := mergeMode(field)
+		if err != nil {
+			return fmt.Errorf("%s: %v", cue.MakePath(selectors(fieldPath)...), err)
+		}
+
+		if field.IncompleteKind() == cue.StructKind {
+			if isBuild(fieldPath) {
+				l.builds[fieldPath[1]] = l.dir
+			}
+
+			sub, ok := dst[label].(map[string]any)
+			if !ok {
+				sub = map[string]any{}
+				dst[label] = sub
+			}
+
+			err := l.merge(sub, field, fieldPath)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		value, _ := field.Default()
+		if !value.IsConcrete() {
+			continue
+		}
+
+		// defaults don't override what an earlier file set explicitly
+		if _, ok := dst[label]; ok && !field.IsConcrete() {
+			continue
+		}
+
+		var x any
+		err = value.Decode(&x)
+		if err != nil {
+			// incomplete values are left to the files after this one
+			continue
+		}
+
+		if s, ok := x.(string); ok && isBuildPath(fieldPath) && s != "" && !filepath.IsAbs(s) {
+			x = filepath.Join(l.dir, s)
+		}
+
+		if list, ok := x.([]any); ok && mode == "append" {
+			if existing, ok := dst[label].([]any); ok {
+				x = append(append([]any{}, existing...), list...)
+			}
+		}
+
+		dst[label] = x
+	}
+
+	return nil
+}
+
+// mergeMode reads the @dbx attribute of a field, which decides whether a
+// list replaces or appends to the one set by earlier files.
+func mergeMode(v cue.Value) (string, error) {
+	attr := v.Attribute("dbx")
+	if attr.Err() != nil {
+		return "replace", nil
+	}
+
+	mode, err := attr.String(0)
+	if err != nil {
+		return "", err
+	}
+
+	switch mode {
+	case "append", "replace":
+		if v.IncompleteKind() != cue.ListKind {
+			return "", fmt.Errorf("@dbx(%s) can only be used on lists", mode)
+		}
+
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid @dbx(%s): must be append or replace", mode)
+	}
+}
+
+func selectors(path []string) []cue.Selector {
+	sels := make([]cue.Selector, len(path))
+	for i, p := range path {
+		sels[i] = cue.Str(p)
+	}
+
+	return sels
+}
+
+func isBuild(path []string) bool {
+	return len(path) == 3 && path[0] == "applets" && path[2] == "build"
+}
+
+func isBuildPath(path []string) bool {
+	return len(path) == 4 && isBuild(path[:3]) && (path[3] == "context" || path[3] == "dockerfile")
+}
+
+// resolveLayeredBuilds makes the build paths left relative by the schema's
+// defaults absolute, relative to the last file setting the build block.
+func resolveLayeredBuilds(root *applet.Root, builds map[string]string) {
+	for name, a := range root.Applets {
+		if a.Build == nil {
+			continue
+		}
+
+		for _, p := range []*string{&a.Build.Context, &a.Build.Dockerfile} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(builds[name], *p)
+			}
+		}
+	}
+}
@@ -9,41 +9,114 @@
 
   restart?: string | "no" | "always" | "on-failure" | "unless-stopped"
   hostname?: string
+  runtime?: string
+  scope?: #Scope
+  user?: string
+  pull_policy?: "missing" | "always" | "never" | "daily" | string
+  env_filter?: string
+  network_mode?: string | "none" | "host" | "bridge"
+
+  memory?: string
+  memory_swap?: string
+  cpus?: string
+  cpu_shares?: int
+  pids_limit?: int
+  shm_size?: string
+  ulimits?: [string]: #Ulimit
+  build?: #Build
+  if build != _|_ {
+    image: string | *"dockerbox/\(applet_name)"
+  }
 
   interactive: bool | *true
   tty: bool | *true
   rm: bool | *true
 
   kill?: bool
+  persistent?: bool
+  idle_timeout?: string
   pull?: bool
   detach?: bool
   privileged?: bool
+  hardened?: bool
+  host_user?: bool
+  all_envs?: bool
+  inverse?: bool
+  translate_paths?: bool
+  rewrite_output?: bool
+  host_groups?: bool
+  read_only?: bool
+  no_new_privileges?: bool
 
   after_hooks?: [...#Applet]
   before_hooks?: [...#Applet]
+  cap_add?: [...string]
+  cap_drop?: [...string]
   command?: [...string]
   dns?: [...string]
   dns_option?: [...string]
   dns_search?: [...string]
   environment?: [...string]
   env_file?: [...string]
+  group_add?: [...string]
+  labels?: [...string]
   links?: [...string]
   ports?: [...string]
   volumes?: [...string]
   networks?: [...string]
+  security_opt?: [...string]
+  tmpfs?: [...string]
+}
+
+#Build: {
+  context: string | *"."
+  dockerfile?: string
+  target?: string
+  args?: [string]: string
+}
+
+#Ulimit: {
+  soft: int
+  hard?: int
 }
 
 #Network: {
   name: string
   driver?: string
+  scope?: #Scope
 }
 
 #Volume: {
   name: string
   driver?: string
+  scope?: #Scope
 }
 
+#Scope: "project" | "global"
+
 environ: [string]: string
+host: {
+  uid: int
+  gid: int
+  groups: [...int]
+  user: string
+}
+invocation: {
+  applet: string
+  args: [...string]
+  subcommand: string
+  uid: int
+  gid: int
+  os: string
+  arch: string
+  hostname: string
+  cwd: string
+  git_root: string
+  version: string
+}
+root?: bool
+runtime?: string
+scope?: #Scope
 applets: [string]: #Applet
 networks: [string]: #Network
 volumes: [string]: #Volume
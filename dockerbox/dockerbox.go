@@ -13,11 +13,27 @@ type Config struct {
 	RootDir    string `envconfig:"DOCKERBOX_ROOT_DIR" default:"$HOME/.dockerbox"`
 	InstallDir string `envconfig:"DOCKERBOX_INSTALL_DIR" default:"$HOME/.dockerbox/bin"`
 	Separator  string `envconfig:"DOCKERBOX_SEPARATOR" default:"--"`
+	Runtime    string `envconfig:"DOCKERBOX_RUNTIME"`
+	Backend    string `envconfig:"DOCKERBOX_BACKEND" default:"cli"`
+	DockerHost string `envconfig:"DOCKER_HOST" default:"unix:///var/run/docker.sock"`
+	DryRun     bool   `envconfig:"DOCKERBOX_DRY_RUN"`
+	Cache      bool   `envconfig:"DOCKERBOX_CACHE" default:"true"`
+	Offline    bool   `envconfig:"DOCKERBOX_OFFLINE"`
+	Layered    bool   `envconfig:"DOCKERBOX_LAYERED"`
+	// ConfigPath lists extra config directories and files, separated like
+	// PATH.
+	ConfigPath    string `envconfig:"DOCKERBOX_CONFIG_PATH"`
+	XDGConfigHome string `envconfig:"XDG_CONFIG_HOME" default:"$HOME/.config"`
 
 	WD           string
 	DockerboxExe string
 	EntryPoint   string
 	Args         []string
+	Host         Host   `ignored:"true"`
+	LockFile     string `ignored:"true"`
+	// ProjectDir holds the project config closest to the working
+	// directory, see ProjectDir. It is empty without project configs.
+	ProjectDir string `ignored:"true"`
 }
 
 func New(ent, wd, exe string, args []string) (*Config, error) {
@@ -26,6 +42,7 @@ func New(ent, wd, exe string, args []string) (*Config, error) {
 		WD:           wd,
 		DockerboxExe: exe,
 		Args:         args,
+		Host:         CurrentHost(),
 	}
 
 	err := envconfig.Process("", cfg)
@@ -35,47 +52,34 @@ func New(ent, wd, exe string, args []string) (*Config, error) {
 
 	cfg.RootDir = os.ExpandEnv(cfg.RootDir)
 	cfg.InstallDir = os.ExpandEnv(cfg.InstallDir)
+	cfg.XDGConfigHome = os.ExpandEnv(cfg.XDGConfigHome)
 
 	return cfg, nil
 }
 
-func GetConfigurations(fs afero.Fs, wd, rootDir string) ([]string, error) {
+func readDir(fs afero.Fs, currentDir string) ([]string, error) {
 	files := []string{}
 
-	dirFiles, err := readDir(fs, rootDir)
+	dir, err := afero.ReadDir(fs, currentDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read dir %s: %v", rootDir, err)
+		return nil, fmt.Errorf("failed to read dir %s: %v", currentDir, err)
 	}
-	files = append(files, dirFiles...)
 
-	currentDir := wd
-	for currentDir != "/" {
-		dirFiles, err := readDir(fs, currentDir)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read dir %s: %v", currentDir, err)
+	for _, file := range dir {
+		if !file.IsDir() && isConfig(file.Name()) {
+			files = append(files, filepath.Join(currentDir, file.Name()))
 		}
-		files = append(files, dirFiles...)
-
-		currentDir = filepath.Dir(currentDir)
 	}
 
 	return files, nil
 }
 
-func readDir(fs afero.Fs, currentDir string) ([]string, error) {
-	files := []string{}
-
-	dir, err := afero.ReadDir(fs, currentDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read dir %s: %v", dir, err)
-	}
-
-	for _, file := range dir {
-		ok, _ := filepath.Match("*.dbx.cue", file.Name())
-		if !file.IsDir() && ok {
-			files = append(files, filepath.Join(currentDir, file.Name()))
+func isConfig(name string) bool {
+	for _, pattern := range ConfigPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
 		}
 	}
 
-	return files, nil
+	return false
 }
@@ -21,13 +21,18 @@ func TestNew(t *testing.T) {
 				"HOME": "/root",
 			},
 			cfg: &Config{
-				RootDir:      "/root/.dockerbox",
-				InstallDir:   "/root/.dockerbox/bin",
-				Separator:    "--",
-				WD:           "",
-				DockerboxExe: "",
-				EntryPoint:   "",
-				Args:         []string{},
+				RootDir:       "/root/.dockerbox",
+				InstallDir:    "/root/.dockerbox/bin",
+				Separator:     "--",
+				Backend:       "cli",
+				DockerHost:    "unix:///var/run/docker.sock",
+				Cache:         true,
+				XDGConfigHome: "/root/.config",
+				WD:            "",
+				DockerboxExe:  "",
+				EntryPoint:    "",
+				Args:          []string{},
+				Host:          CurrentHost(),
 			},
 		},
 		{
@@ -36,15 +41,33 @@ func TestNew(t *testing.T) {
 				"DOCKERBOX_ROOT_DIR":    "/foo",
 				"DOCKERBOX_INSTALL_DIR": "/foo/bin",
 				"DOCKERBOX_SEPARATOR":   "***",
+				"DOCKERBOX_RUNTIME":     "podman",
+				"DOCKERBOX_BACKEND":     "engine",
+				"DOCKER_HOST":           "tcp://localhost:2375",
+				"DOCKERBOX_DRY_RUN":     "true",
+				"DOCKERBOX_CACHE":       "false",
+				"DOCKERBOX_OFFLINE":     "true",
+				"DOCKERBOX_LAYERED":     "true",
+				"DOCKERBOX_CONFIG_PATH": "/a:/b",
+				"XDG_CONFIG_HOME":       "/xdg",
 			},
 			cfg: &Config{
-				RootDir:      "/foo",
-				InstallDir:   "/foo/bin",
-				Separator:    "***",
-				WD:           "",
-				DockerboxExe: "",
-				EntryPoint:   "",
-				Args:         []string{},
+				RootDir:       "/foo",
+				InstallDir:    "/foo/bin",
+				Separator:     "***",
+				Runtime:       "podman",
+				Backend:       "engine",
+				DockerHost:    "tcp://localhost:2375",
+				DryRun:        true,
+				Offline:       true,
+				Layered:       true,
+				ConfigPath:    "/a:/b",
+				XDGConfigHome: "/xdg",
+				WD:            "",
+				DockerboxExe:  "",
+				EntryPoint:    "",
+				Args:          []string{},
+				Host:          CurrentHost(),
 			},
 		},
 	}
@@ -55,6 +78,15 @@ func TestNew(t *testing.T) {
 			os.Unsetenv("DOCKERBOX_ROOT_DIR")
 			os.Unsetenv("DOCKERBOX_INSTALL_DIR")
 			os.Unsetenv("DOCKERBOX_SEPARATOR")
+			os.Unsetenv("DOCKERBOX_RUNTIME")
+			os.Unsetenv("DOCKERBOX_BACKEND")
+			os.Unsetenv("DOCKER_HOST")
+			os.Unsetenv("DOCKERBOX_DRY_RUN")
+			os.Unsetenv("DOCKERBOX_CACHE")
+			os.Unsetenv("DOCKERBOX_OFFLINE")
+			os.Unsetenv("DOCKERBOX_LAYERED")
+			os.Unsetenv("DOCKERBOX_CONFIG_PATH")
+			os.Unsetenv("XDG_CONFIG_HOME")
 
 			for k, v := range tc.envs {
 				os.Setenv(k, v)
@@ -72,8 +104,10 @@ func TestGetConfigurations(t *testing.T) {
 	tt := []struct {
 		name string
 
-		configs  []string
-		expected []string
+		configs    map[string]string
+		configPath string
+		untrusted  map[string]bool
+		expected   []Source
 
 		wd   string
 		root string
@@ -84,38 +118,119 @@ func TestGetConfigurations(t *testing.T) {
 			name: "finds files relative to the working directory",
 			wd:   "/src/foo",
 			root: "/root",
-			configs: []string{
-				"/src/foo/test.dbx.cue",
-				"/src/test.dbx.cue",
+			configs: map[string]string{
+				"/src/foo/test.dbx.cue": "",
+				"/src/test.dbx.cue":     "",
 			},
-			expected: []string{
-				"/src/foo/test.dbx.cue",
-				"/src/test.dbx.cue",
+			expected: []Source{
+				{Path: "/src/test.dbx.cue", Origin: Project},
+				{Path: "/src/foo/test.dbx.cue", Origin: Project},
 			},
 		},
 		{
 			name: "only find files with the .dbx.cue extension",
 			wd:   "/src/foo",
 			root: "/root",
-			configs: []string{
-				"/src/foo/test.dbx.cue",
-				"/src/test.dbx.cue",
-				"/src/test.cue",
+			configs: map[string]string{
+				"/src/foo/test.dbx.cue": "",
+				"/src/test.dbx.cue":     "",
+				"/src/test.cue":         "",
 			},
-			expected: []string{
-				"/src/foo/test.dbx.cue",
-				"/src/test.dbx.cue",
+			expected: []Source{
+				{Path: "/src/test.dbx.cue", Origin: Project},
+				{Path: "/src/foo/test.dbx.cue", Origin: Project},
 			},
 		},
 		{
 			name: "finds files in the root directory",
 			wd:   "/src/foo",
 			root: "/root",
-			configs: []string{
-				"/root/test.dbx.cue",
+			configs: map[string]string{
+				"/root/test.dbx.cue": "",
 			},
-			expected: []string{
-				"/root/test.dbx.cue",
+			expected: []Source{
+				{Path: "/root/test.dbx.cue", Origin: Root},
+			},
+		},
+		{
+			name: "stops at a root marker",
+			wd:   "/src/foo/bar",
+			root: "/root",
+			configs: map[string]string{
+				"/src/test.dbx.cue":         "",
+				"/src/foo/test.dbx.cue":     "root: true\napplets: {}",
+				"/src/foo/bar/test.dbx.cue": "root: false",
+			},
+			expected: []Source{
+				{Path: "/src/foo/test.dbx.cue", Origin: Project},
+				{Path: "/src/foo/bar/test.dbx.cue", Origin: Project},
+			},
+		},
+		{
+			name: "finds yaml and json files",
+			wd:   "/src",
+			root: "/root",
+			configs: map[string]string{
+				"/src/a.dbx.yaml": "",
+				"/src/b.dbx.yml":  "",
+				"/src/c.dbx.json": "",
+				"/src/d.dbx.cue":  "",
+				"/src/e.yaml":     "",
+			},
+			expected: []Source{
+				{Path: "/src/a.dbx.yaml", Origin: Project},
+				{Path: "/src/b.dbx.yml", Origin: Project},
+				{Path: "/src/c.dbx.json", Origin: Project},
+				{Path: "/src/d.dbx.cue", Origin: Project},
+			},
+		},
+		{
+			name: "stops at a root marker in yaml and json",
+			wd:   "/src/foo/bar",
+			root: "/root",
+			configs: map[string]string{
+				"/src/test.dbx.cue":          "",
+				"/src/foo/test.dbx.yaml":     "root: true\napplets: {}",
+				"/src/foo/bar/test.dbx.json": `{"root": true}`,
+			},
+			expected: []Source{
+				{Path: "/src/foo/bar/test.dbx.json", Origin: Project},
+			},
+		},
+		{
+			name: "ignores root markers of untrusted configs",
+			wd:   "/src/foo",
+			root: "/root",
+			configs: map[string]string{
+				"/src/test.dbx.cue":     "",
+				"/src/foo/test.dbx.cue": "root: true",
+			},
+			untrusted: map[string]bool{"/src/foo/test.dbx.cue": true},
+			expected: []Source{
+				{Path: "/src/test.dbx.cue", Origin: Project},
+				{Path: "/src/foo/test.dbx.cue", Origin: Project},
+			},
+		},
+		{
+			name:       "orders sources by precedence",
+			wd:         "/src",
+			root:       "/root/.dockerbox",
+			configPath: "/opt/tools:/opt/extra.dbx.cue",
+			configs: map[string]string{
+				"/src/test.dbx.cue":                    "",
+				"/opt/extra.dbx.cue":                   "",
+				"/opt/tools/tools.dbx.cue":             "",
+				"/root/.dockerbox/test.dbx.cue":        "",
+				"/root/.config/dockerbox/test.dbx.cue": "",
+				"/etc/dockerbox/test.dbx.cue":          "",
+			},
+			expected: []Source{
+				{Path: "/etc/dockerbox/test.dbx.cue", Origin: System},
+				{Path: "/root/.config/dockerbox/test.dbx.cue", Origin: User},
+				{Path: "/root/.dockerbox/test.dbx.cue", Origin: Root},
+				{Path: "/opt/tools/tools.dbx.cue", Origin: ConfigPath},
+				{Path: "/opt/extra.dbx.cue", Origin: ConfigPath},
+				{Path: "/src/test.dbx.cue", Origin: Project},
 			},
 		},
 	}
@@ -134,16 +249,38 @@ func TestGetConfigurations(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			for _, config := range tc.configs {
-				err = afero.WriteFile(fs, config, []byte(""), 0644)
+			for config, data := range tc.configs {
+				err = afero.WriteFile(fs, config, []byte(data), 0644)
 				if err != nil {
 					t.Fatal(err)
 				}
 			}
 
-			actual, err := GetConfigurations(fs, tc.wd, tc.root)
+			actual, err := GetConfigurations(fs, &Config{
+				WD:            tc.wd,
+				RootDir:       tc.root,
+				ConfigPath:    tc.configPath,
+				XDGConfigHome: "/root/.config",
+			}, func(path string) bool {
+				return !tc.untrusted[path]
+			})
 			assert.Equal(t, tc.err, err)
 			assert.Equal(t, tc.expected, actual)
 		})
 	}
 }
+
+func TestProjectDir(t *testing.T) {
+	assert.Equal(t, "/src/foo", ProjectDir([]Source{{"/root/a.dbx.cue", Root}, {"/src/c.dbx.cue", Project}, {"/src/foo/b.dbx.cue", Project}}))
+	assert.Equal(t, "", ProjectDir([]Source{{"/root/a.dbx.cue", Root}}))
+}
+
+func TestInvocation(t *testing.T) {
+	cfg := &Config{EntryPoint: "kubectl", Separator: "--", Args: []string{"--tty", "--", "--v=2", "proxy", "--port", "8001"}, WD: "/src"}
+
+	inv := cfg.Invocation()
+	assert.Equal(t, "kubectl", inv.Applet)
+	assert.Equal(t, []string{"--v=2", "proxy", "--port", "8001"}, inv.Args)
+	assert.Equal(t, "proxy", inv.Subcommand)
+	assert.Equal(t, "/src", inv.CWD)
+}
@@ -0,0 +1,45 @@
+package dockerbox
+
+import (
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/ast/astutil"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/encoding/json"
+	"cuelang.org/go/encoding/yaml"
+)
+
+// ConfigPatterns match the names of config files, CUE first. YAML and JSON
+// configs are plain data and can't refer to the schema or each other.
+var ConfigPatterns = []string{"*.dbx.cue", "*.dbx.yaml", "*.dbx.yml", "*.dbx.json"}
+
+// IsData reports whether filename is a YAML or JSON config.
+func IsData(filename string) bool {
+	return !strings.HasSuffix(filename, ".cue")
+}
+
+// ParseConfig parses a config file in any of the supported formats into
+// CUE syntax. Positions in the result, and so in errors, point into the
+// original file.
+func ParseConfig(filename string, src []byte) (*ast.File, error) {
+	switch {
+	case strings.HasSuffix(filename, ".json"):
+		expr, err := json.Extract(filename, src)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := astutil.ToFile(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.Filename = filename
+
+		return f, nil
+	case strings.HasSuffix(filename, ".yaml"), strings.HasSuffix(filename, ".yml"):
+		return yaml.Extract(filename, src)
+	default:
+		return parser.ParseFile(filename, src)
+	}
+}
@@ -0,0 +1,38 @@
+package dockerbox
+
+import (
+	"os"
+	"os/user"
+)
+
+// Host identifies the user invoking dockerbox, so containers can run as
+// them and configs can refer to them.
+type Host struct {
+	UID    int    `json:"uid"`
+	GID    int    `json:"gid"`
+	Groups []int  `json:"groups"`
+	User   string `json:"user"`
+}
+
+func CurrentHost() Host {
+	h := Host{
+		UID:    os.Getuid(),
+		GID:    os.Getgid(),
+		Groups: []int{},
+	}
+
+	groups, err := os.Getgroups()
+	if err == nil {
+		for _, g := range groups {
+			if g != h.GID {
+				h.Groups = append(h.Groups, g)
+			}
+		}
+	}
+
+	if u, err := user.Current(); err == nil {
+		h.User = u.Username
+	}
+
+	return h
+}
@@ -0,0 +1,87 @@
+package dockerbox
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/version"
+)
+
+// Invocation describes what dockerbox was invoked to run, so configs can
+// vary by it, e.g. publishing a port only for `kubectl proxy`.
+type Invocation struct {
+	Applet string `json:"applet"`
+	// Args are the arguments passed on to the applet.
+	Args []string `json:"args"`
+	// Subcommand is the first of Args that isn't a flag.
+	Subcommand string `json:"subcommand"`
+	UID        int    `json:"uid"`
+	GID        int    `json:"gid"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	Hostname   string `json:"hostname"`
+	CWD        string `json:"cwd"`
+	GitRoot    string `json:"git_root"`
+	Version    string `json:"version"`
+}
+
+func (cfg *Config) Invocation() Invocation {
+	_, args := cfg.SplitArgs()
+
+	inv := Invocation{
+		Applet:  cfg.EntryPoint,
+		Args:    append([]string{}, args...),
+		UID:     cfg.Host.UID,
+		GID:     cfg.Host.GID,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		CWD:     cfg.WD,
+		GitRoot: GitRoot(cfg.WD),
+		Version: version.Version,
+	}
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			inv.Subcommand = arg
+			break
+		}
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		inv.Hostname = hostname
+	}
+
+	return inv
+}
+
+// SplitArgs splits the arguments into dockerbox's flags and the arguments
+// for the applet at the separator. Without a separator everything belongs
+// to the applet.
+func (cfg *Config) SplitArgs() ([]string, []string) {
+	for i, arg := range cfg.Args {
+		if arg == cfg.Separator {
+			return cfg.Args[:i], cfg.Args[i+1:]
+		}
+	}
+	return []string{}, cfg.Args
+}
+
+// GitRoot returns the root of the git checkout containing dir, or "" when
+// dir isn't inside one.
+func GitRoot(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+
+		if d == filepath.Dir(d) {
+			return ""
+		}
+	}
+}
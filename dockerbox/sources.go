@@ -0,0 +1,189 @@
+package dockerbox
+
+import (
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue/ast"
+	"github.com/spf13/afero"
+)
+
+// SystemDir holds configs shared by every user of the machine.
+const SystemDir = "/etc/dockerbox"
+
+// Origin tells where a config file was found.
+type Origin string
+
+const (
+	System     Origin = "system"
+	User       Origin = "user"
+	Root       Origin = "root"
+	ConfigPath Origin = "config path"
+	// Project configs are found walking up from the working directory.
+	Project Origin = "project"
+)
+
+// Source is a config file and where it was found.
+type Source struct {
+	Path   string
+	Origin Origin
+}
+
+// GetConfigurations returns the config files in precedence order, lowest
+// first: the system dir, $XDG_CONFIG_HOME/dockerbox, the root dir, the
+// existing entries of DOCKERBOX_CONFIG_PATH and finally the project configs from
+// the farthest directory to the working directory. The walk up from the
+// working directory stops at a directory with a config setting
+// `root: true`, which is only honoured in project configs that are trusted
+// so an untrusted one can't hide the configs above it.
+func GetConfigurations(fs afero.Fs, cfg *Config, trusted func(path string) bool) ([]Source, error) {
+	sources := []Source{}
+	seen := map[string]bool{}
+
+	add := func(origin Origin, files ...string) {
+		for _, f := range files {
+			if !seen[f] {
+				seen[f] = true
+				sources = append(sources, Source{Path: f, Origin: origin})
+			}
+		}
+	}
+
+	for _, dir := range []struct {
+		path   string
+		origin Origin
+	}{
+		{SystemDir, System},
+		{filepath.Join(cfg.XDGConfigHome, "dockerbox"), User},
+	} {
+		if cfg.XDGConfigHome == "" && dir.origin == User {
+			continue
+		}
+
+		if ok, _ := afero.DirExists(fs, dir.path); !ok {
+			continue
+		}
+
+		files, err := readDir(fs, dir.path)
+		if err != nil {
+			return nil, err
+		}
+		add(dir.origin, files...)
+	}
+
+	files, err := readDir(fs, cfg.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	add(Root, files...)
+
+	for _, p := range filepath.SplitList(cfg.ConfigPath) {
+		if p == "" {
+			continue
+		}
+
+		info, err := fs.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			add(ConfigPath, p)
+			continue
+		}
+
+		files, err := readDir(fs, p)
+		if err != nil {
+			return nil, err
+		}
+		add(ConfigPath, files...)
+	}
+
+	project := [][]string{}
+	for currentDir := cfg.WD; currentDir != "" && currentDir != "/"; currentDir = filepath.Dir(currentDir) {
+		files, err := readDir(fs, currentDir)
+		if err != nil {
+			return nil, err
+		}
+		project = append(project, files)
+
+		marked := []string{}
+		for _, f := range files {
+			if trusted(f) {
+				marked = append(marked, f)
+			}
+		}
+
+		if isRoot(fs, marked) {
+			break
+		}
+	}
+
+	for i := len(project) - 1; i >= 0; i-- {
+		add(Project, project[i]...)
+	}
+
+	return sources, nil
+}
+
+// Paths returns the paths of sources.
+func Paths(sources []Source) []string {
+	paths := make([]string, len(sources))
+	for i, s := range sources {
+		paths[i] = s.Path
+	}
+
+	return paths
+}
+
+// ProjectDir returns the directory of the project config closest to the
+// working directory, or "" without project configs.
+func ProjectDir(sources []Source) string {
+	for i := len(sources) - 1; i >= 0; i-- {
+		if sources[i].Origin == Project {
+			return filepath.Dir(sources[i].Path)
+		}
+	}
+
+	return ""
+}
+
+// isRoot reports whether one of files sets `root: true` at the top level.
+// Files that fail to parse are left for the compilation to report.
+func isRoot(fs afero.Fs, files []string) bool {
+	for _, f := range files {
+		bytes, err := afero.ReadFile(fs, f)
+		if err != nil {
+			continue
+		}
+
+		file, err := ParseConfig(f, bytes)
+		if err != nil {
+			continue
+		}
+
+		for _, decl := range file.Decls {
+			field, ok := decl.(*ast.Field)
+			if !ok {
+				continue
+			}
+
+			name, _, err := ast.LabelName(field.Label)
+			if err != nil || name != "root" {
+				continue
+			}
+
+			if lit, ok := field.Value.(*ast.BasicLit); ok && lit.Value == "true" {
+				return true
+			}
+			if id, ok := field.Value.(*ast.Ident); ok && id.Name == "true" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -1,5 +1,7 @@
 package tools
 
+import "dockerbox.dev/catalog/v1:catalog"
+
 applets: [Name=_]: {
   applet_name: Name
 }
@@ -12,45 +14,21 @@ networks: [Name=_]: {
   name: Name
 }
 
-baseEnvs: [
-  for k, v in environ if k != "HOME" && k != "PATH" && k != "TMPDIR" {
-  	"\(k)=\(v)"
-  },
-]
-
-baseVolumes: ["\(environ.PWD):/src"]
-
-#base: #Applet & {
-  applet_name: string
-  entrypoint:  string | *"\(applet_name)"
-  work_dir:    string | *"/src"
-  environment: [...string] | *baseEnvs
-  volumes:     [...string] | *baseVolumes
-}
-
-#ruby: #base & {
-  image:   "ruby"
-  volumes: ["bundle:/usr/local/bundle"] + baseVolumes
+#ruby: #Applet & catalog.#Ruby & {
+  #project: environ.PWD
 }
 
-#node: #base & {
-  image:   "node"
-  volumes: [
-  	"yarn:/root/.yarn",
-  	"node:/usr/local/lib/node_modules",
-  ] + baseVolumes
-  ports: ["3000:3000", "5173:5173"]
+#node: #Applet & catalog.#Node & {
+  #project: environ.PWD
 }
 
-#rust: #base & {
-  image: "rust"
-  volumes: ["cargo:/root/.cargo"] + baseVolumes
-  ports: ["8000:8000"]
+#rust: #Applet & catalog.#Rust & {
+  #project: environ.PWD
 }
 
 
 applets: {
-  rust:    #rust & {}
+  rust:    #rust & {entrypoint: "rustc"}
   cargo:   #rust & {}
 
   ruby:    #ruby & {}
@@ -63,7 +41,7 @@ applets: {
   npx:     #node & {}
   npm:     #node & {}
   pnpm:    #node & {}
-  tsc:     #node & {}
+  tsc:     #node & {entrypoint: "npx", command: ["--package", "typescript", "tsc"]}
 
   ...
 }
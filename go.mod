@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	cuelang.org/go v0.4.3
+	github.com/creack/pty v1.1.18
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/octago/sflags v0.3.1-0.20210726012706-20f2a9c31dfc
 	github.com/spf13/afero v1.9.3
@@ -27,5 +28,5 @@ require (
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,106 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const Filename = "dockerbox.lock"
+
+// Lock pins image references, e.g. `hashicorp/terraform:latest`, to the
+// digest they resolved to, e.g. `hashicorp/terraform@sha256:...`.
+type Lock struct {
+	Images map[string]string `json:"images"`
+}
+
+// Path returns the lock file of the project, next to the project config
+// closest to the working directory. Without project configs the lock file
+// lives in the root dir.
+func Path(projectDir, rootDir string) string {
+	if projectDir == "" {
+		return filepath.Join(rootDir, Filename)
+	}
+
+	return filepath.Join(projectDir, Filename)
+}
+
+// Load reads the lock file at path. A missing file pins nothing.
+func Load(fs afero.Fs, path string) (*Lock, error) {
+	l := &Lock{Images: map[string]string{}}
+
+	bytes, err := afero.ReadFile(fs, path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	err = json.Unmarshal(bytes, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if l.Images == nil {
+		l.Images = map[string]string{}
+	}
+
+	return l, nil
+}
+
+func (l *Lock) Write(fs afero.Fs, path string) error {
+	bytes, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lock: %v", err)
+	}
+
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+
+	err = afero.WriteFile(fs, tmp, append(bytes, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	err = fs.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Check compares the lock with the digests the config's image references
+// currently resolve to, reporting references missing from the lock, pinned
+// to another digest, and entries no longer referenced by the config.
+func (l *Lock) Check(digests map[string]string) error {
+	problems := []string{}
+
+	for ref, digest := range digests {
+		locked, ok := l.Images[ref]
+		switch {
+		case !ok:
+			problems = append(problems, ref+" is not locked")
+		case locked != digest:
+			problems = append(problems, fmt.Sprintf("%s is locked to %s but resolves to %s", ref, locked, digest))
+		}
+	}
+
+	for ref := range l.Images {
+		if _, ok := digests[ref]; !ok {
+			problems = append(problems, ref+" is locked but not used")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("lock is out of date: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
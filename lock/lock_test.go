@@ -0,0 +1,49 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "/src/app/dockerbox.lock", Path("/src/app", "/root/.dockerbox"))
+	assert.Equal(t, "/root/.dockerbox/dockerbox.lock", Path("", "/root/.dockerbox"))
+}
+
+func TestLoadWrite(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	l, err := Load(fs, "/src/dockerbox.lock")
+	assert.Nil(t, err)
+	assert.Empty(t, l.Images)
+
+	l.Images["alpine:latest"] = "alpine@sha256:abc"
+	err = l.Write(fs, "/src/dockerbox.lock")
+	assert.Nil(t, err)
+
+	l, err = Load(fs, "/src/dockerbox.lock")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"alpine:latest": "alpine@sha256:abc"}, l.Images)
+}
+
+func TestCheck(t *testing.T) {
+	l := &Lock{Images: map[string]string{
+		"alpine:latest": "alpine@sha256:abc",
+		"ruby:3":        "ruby@sha256:def",
+	}}
+
+	assert.Nil(t, l.Check(map[string]string{"alpine:latest": "alpine@sha256:abc", "ruby:3": "ruby@sha256:def"}))
+	assert.Equal(
+		t,
+		errors.New("lock is out of date: node:latest is not locked, ruby:3 is locked but not used"),
+		l.Check(map[string]string{"alpine:latest": "alpine@sha256:abc", "node:latest": "node@sha256:123"}),
+	)
+	assert.Equal(
+		t,
+		errors.New("lock is out of date: alpine:latest is locked to alpine@sha256:abc but resolves to alpine@sha256:fed"),
+		l.Check(map[string]string{"alpine:latest": "alpine@sha256:fed", "ruby:3": "ruby@sha256:def"}),
+	)
+}
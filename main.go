@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
+	"github.com/sethpollack/dockerbox/applet"
+	"github.com/sethpollack/dockerbox/cache"
 	"github.com/sethpollack/dockerbox/cmd"
 	"github.com/sethpollack/dockerbox/cue"
 	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/sethpollack/dockerbox/lock"
 	"github.com/sethpollack/dockerbox/runner"
+	"github.com/sethpollack/dockerbox/trust"
 	"github.com/spf13/afero"
 )
 
@@ -39,13 +43,52 @@ func main() {
 		os.Exit(1)
 	}
 
-	files, err := dockerbox.GetConfigurations(fs, wd, cfg.RootDir)
+	store, err := trust.Load(fs, trust.Path(cfg.RootDir))
+	if err != nil {
+		fmt.Printf("failed to load trusted configs: %v", err)
+		os.Exit(1)
+	}
+
+	sources, err := dockerbox.GetConfigurations(fs, cfg, store.Trusts(fs))
 	if err != nil {
 		fmt.Printf("failed to get configurations: %v", err)
 		os.Exit(1)
 	}
 
-	root, err := cue.New(fs, files)
+	sources, untrusted := store.Filter(fs, sources)
+	for _, src := range untrusted {
+		if store.Status(fs, src.Path) == trust.Changed {
+			fmt.Fprintf(os.Stderr, "dockerbox: skipping %s, it changed since it was allowed, run `dockerbox allow` to trust it again\n", src.Path)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "dockerbox: skipping untrusted %s, run `dockerbox allow` to trust it\n", src.Path)
+	}
+
+	files := dockerbox.Paths(sources)
+
+	cfg.ProjectDir = dockerbox.ProjectDir(sources)
+	cfg.LockFile = lock.Path(cfg.ProjectDir, cfg.RootDir)
+
+	// a project's lock can swap the images of its applets, so it needs to be
+	// allowed like its configs
+	locked := true
+	if cfg.ProjectDir != "" {
+		switch store.Status(fs, cfg.LockFile) {
+		case trust.Changed:
+			locked = false
+			fmt.Fprintf(os.Stderr, "dockerbox: skipping %s, it changed since it was allowed, run `dockerbox allow` to trust it again\n", cfg.LockFile)
+		case trust.Untrusted:
+			locked = false
+			fmt.Fprintf(os.Stderr, "dockerbox: skipping untrusted %s, run `dockerbox allow` to trust it\n", cfg.LockFile)
+		}
+	}
+
+	// configs can refer to the invocation, so they are compiled per applet
+	load := func(cfg *dockerbox.Config) (*applet.Root, error) {
+		return loadRoot(fs, files, cfg, locked)
+	}
+
+	root, err := load(cfg)
 	if err != nil {
 		fmt.Printf("failed to load applets: %v", err)
 		os.Exit(1)
@@ -61,7 +104,7 @@ func main() {
 
 	switch cfg.EntryPoint {
 	case "dockerbox":
-		command, err := cmd.NewRootCmd(cfg, root)
+		command, err := cmd.NewRootCmd(cfg, root, load)
 		if err != nil {
 			fmt.Printf("failed to create root command: %v", err)
 			os.Exit(1)
@@ -79,13 +122,71 @@ func main() {
 			os.Exit(1)
 		}
 
-		err = runner.RunCmds(cmds)
+		if cfg.DryRun {
+			err := runner.Explain(os.Stdout, cmds)
+			if err != nil {
+				fmt.Printf("failed to explain applet: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		// reaping is housekeeping and must not keep the applet from running
+		err = root.Reap(cfg)
 		if err != nil {
-			exiterr, ok := err.(*exec.ExitError)
-			if ok {
+			fmt.Fprintf(os.Stderr, "failed to reap idle containers: %v\n", err)
+		}
+
+		backend, err := runner.NewBackend(cfg.Backend, cfg.DockerHost)
+		if err != nil {
+			fmt.Printf("failed to create backend: %v", err)
+			os.Exit(1)
+		}
+
+		err = runner.RunCmds(backend, cmds)
+		if err != nil {
+			var exiterr interface{ ExitCode() int }
+			if errors.As(err, &exiterr) {
+				// the applet's own exit passes through silently, a failed
+				// dependency keeps its code but says what failed
+				if e, ok := exiterr.(error); !ok || e != err {
+					fmt.Fprintf(os.Stderr, "failed to run applet: %v\n", err)
+				}
 				os.Exit(exiterr.ExitCode())
 			}
+			fmt.Printf("failed to run applet: %v", err)
 			os.Exit(1)
 		}
 	}
 }
+
+func loadRoot(fs afero.Fs, files []string, cfg *dockerbox.Config, locked bool) (*applet.Root, error) {
+	opts := cue.Options{
+		Invocation: cfg.Invocation(),
+		Host:       cfg.Host,
+		Layered:    cfg.Layered,
+	}
+
+	var root *applet.Root
+	var err error
+	if cfg.Cache {
+		root, err = cue.NewCached(fs, files, opts, cache.New(fs, cache.Dir(cfg.RootDir)))
+	} else {
+		root, err = cue.New(fs, files, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		return root, nil
+	}
+
+	l, err := lock.Load(fs, cfg.LockFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lock: %v", err)
+	}
+	root.Locked = l.Images
+
+	return root, nil
+}
@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const engineAPIVersion = "v1.41"
+
+// errUnsupported is returned when a command can not be translated into
+// Engine API calls, in which case the command is handed to the fallback.
+var errUnsupported = errors.New("unsupported by the engine backend")
+
+// Engine runs commands by talking to the Docker Engine API directly,
+// avoiding a client process per command. Commands it does not understand
+// are run with Fallback.
+type Engine struct {
+	Fallback Backend
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	network string
+	address string
+	client  *http.Client
+}
+
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("engine api error (%d): %s", e.Status, e.Message)
+}
+
+func NewEngine(host string) (*Engine, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse docker host %s: %v", host, err)
+	}
+
+	e := &Engine{
+		Fallback: CLI{},
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+	}
+
+	switch u.Scheme {
+	case "unix":
+		e.network, e.address = "unix", u.Path
+	case "tcp":
+		e.network, e.address = "tcp", u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host %s", host)
+	}
+
+	e.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return e.dial(ctx)
+			},
+		},
+	}
+
+	return e, nil
+}
+
+func (e *Engine) Run(cmd Cmd) error {
+	// the commands are compiled in the dialect of their client, which the
+	// engine would silently run against docker instead
+	if len(cmd.Args) > 0 && filepath.Base(cmd.Args[0]) != "docker" {
+		return fmt.Errorf("the engine backend only supports the docker runtime, not %s", cmd.Args[0])
+	}
+
+	err := e.run(cmd)
+	if errors.Is(err, errUnsupported) {
+		return e.Fallback.Run(cmd)
+	}
+
+	return err
+}
+
+func (e *Engine) run(cmd Cmd) error {
+	if len(cmd.Args) < 2 {
+		return errUnsupported
+	}
+
+	stdout := e.Stdout
+	if cmd.Silent {
+		stdout = io.Discard
+	}
+
+	switch args := cmd.Args[1:]; args[0] {
+	case "run":
+		spec, err := parseRun(args[1:])
+		if err != nil {
+			return err
+		}
+
+		return e.runContainer(cmd, spec)
+	case "kill":
+		if len(args) != 2 {
+			return errUnsupported
+		}
+
+		return e.call(http.MethodPost, "/containers/"+args[1]+"/kill", nil, nil, nil)
+	case "image":
+		if len(args) != 3 || args[1] != "inspect" {
+			return errUnsupported
+		}
+
+		return e.inspect("/images/"+args[2]+"/json", stdout)
+	case "network", "volume":
+		if len(args) == 3 && args[1] == "inspect" {
+			return e.inspect("/"+args[0]+"s/"+args[2], stdout)
+		}
+
+		if len(args) < 2 || args[1] != "create" {
+			return errUnsupported
+		}
+
+		name, driver, err := parseCreate(args[2:])
+		if err != nil {
+			return err
+		}
+
+		body := map[string]any{"Name": name}
+		if driver != "" {
+			body["Driver"] = driver
+		}
+
+		if args[0] == "network" {
+			body["CheckDuplicate"] = true
+			return e.call(http.MethodPost, "/networks/create", nil, body, nil)
+		}
+
+		err = e.call(http.MethodPost, "/volumes/create", nil, body, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, name)
+
+		return nil
+	default:
+		// pulls are left to the client so registry credentials keep working
+		return errUnsupported
+	}
+}
+
+func (e *Engine) inspect(path string, stdout io.Writer) error {
+	var out json.RawMessage
+	err := e.call(http.MethodGet, path, nil, nil, &out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, string(out))
+
+	return nil
+}
+
+func (e *Engine) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, e.network, e.address)
+}
+
+func (e *Engine) url(path string, query url.Values) string {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "docker",
+		Path:     "/" + engineAPIVersion + path,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+func (e *Engine) request(method, path string, query url.Values, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %v", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, e.url(path, query), r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// do sends a request and returns the response if the daemon accepted it.
+func (e *Engine) do(method, path string, query url.Values, body any) (*http.Response, error) {
+	req, err := e.request(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker engine: %v", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, readAPIError(resp)
+	}
+
+	return resp, nil
+}
+
+// call sends a request and decodes the response into out, if provided.
+func (e *Engine) call(method, path string, query url.Values, body, out any) error {
+	resp, err := e.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// hijack upgrades a request to a raw stream, as used by attach.
+func (e *Engine) hijack(path string, query url.Values) (net.Conn, *bufio.Reader, error) {
+	req, err := e.request(http.MethodPost, path, query, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := e.dial(context.Background())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to reach docker engine: %v", err)
+	}
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer conn.Close()
+		return nil, nil, readAPIError(resp)
+	}
+
+	return conn, br, nil
+}
+
+func readAPIError(resp *http.Response) error {
+	msg := struct {
+		Message string `json:"message"`
+	}{}
+
+	b, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(b, &msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(b))
+	}
+
+	return &apiError{Status: resp.StatusCode, Message: msg.Message}
+}
@@ -0,0 +1,446 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sethpollack/dockerbox/units"
+	"github.com/spf13/pflag"
+)
+
+type runSpec struct {
+	Name       string
+	WorkDir    string
+	Entrypoint string
+	Restart    string
+	Hostname   string
+	Memory     string
+	MemorySwap string
+	CPUs       string
+	ShmSize    string
+	User       string
+	Userns     string
+	Pull       string
+
+	CPUShares int64
+	PidsLimit int64
+
+	RM          bool
+	Privileged  bool
+	ReadOnly    bool
+	Detach      bool
+	Interactive bool
+	TTY         bool
+
+	DNS       []string
+	DNSSearch []string
+	DNSOption []string
+	Env       []string
+	EnvFile   []string
+	Volumes   []string
+	Networks  []string
+	Ports     []string
+	Links     []string
+	Labels    []string
+	Ulimits   []string
+	CapAdd    []string
+	CapDrop   []string
+	SecOpts   []string
+	Tmpfs     []string
+	GroupAdd  []string
+
+	Image   string
+	Command []string
+}
+
+type containerConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Hostname     string              `json:"Hostname,omitempty"`
+	User         string              `json:"User,omitempty"`
+	Tty          bool                `json:"Tty"`
+	OpenStdin    bool                `json:"OpenStdin"`
+	StdinOnce    bool                `json:"StdinOnce"`
+	AttachStdin  bool                `json:"AttachStdin"`
+	AttachStdout bool                `json:"AttachStdout"`
+	AttachStderr bool                `json:"AttachStderr"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	Binds          []string                 `json:"Binds,omitempty"`
+	NetworkMode    string                   `json:"NetworkMode,omitempty"`
+	PortBindings   map[string][]portBinding `json:"PortBindings,omitempty"`
+	RestartPolicy  restartPolicy            `json:"RestartPolicy"`
+	AutoRemove     bool                     `json:"AutoRemove"`
+	Privileged     bool                     `json:"Privileged"`
+	DNS            []string                 `json:"Dns,omitempty"`
+	DNSOptions     []string                 `json:"DnsOptions,omitempty"`
+	DNSSearch      []string                 `json:"DnsSearch,omitempty"`
+	Links          []string                 `json:"Links,omitempty"`
+	Memory         int64                    `json:"Memory,omitempty"`
+	MemorySwap     int64                    `json:"MemorySwap,omitempty"`
+	NanoCPUs       int64                    `json:"NanoCpus,omitempty"`
+	CPUShares      int64                    `json:"CpuShares,omitempty"`
+	PidsLimit      int64                    `json:"PidsLimit,omitempty"`
+	ShmSize        int64                    `json:"ShmSize,omitempty"`
+	Ulimits        []ulimit                 `json:"Ulimits,omitempty"`
+	CapAdd         []string                 `json:"CapAdd,omitempty"`
+	CapDrop        []string                 `json:"CapDrop,omitempty"`
+	SecurityOpt    []string                 `json:"SecurityOpt,omitempty"`
+	ReadonlyRootfs bool                     `json:"ReadonlyRootfs,omitempty"`
+	Tmpfs          map[string]string        `json:"Tmpfs,omitempty"`
+	GroupAdd       []string                 `json:"GroupAdd,omitempty"`
+	UsernsMode     string                   `json:"UsernsMode,omitempty"`
+}
+
+type ulimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type restartPolicy struct {
+	Name              string `json:"Name,omitempty"`
+	MaximumRetryCount int    `json:"MaximumRetryCount,omitempty"`
+}
+
+// parseRun reads the arguments of a `run` command as produced by the applet
+// package. Unknown flags make the command unsupported.
+func parseRun(args []string) (*runSpec, error) {
+	s := &runSpec{}
+
+	fs := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	fs.SetInterspersed(false)
+	fs.SetOutput(io.Discard)
+
+	fs.StringVar(&s.Name, "name", "", "")
+	fs.StringVarP(&s.WorkDir, "workdir", "w", "", "")
+	fs.StringVar(&s.Entrypoint, "entrypoint", "", "")
+	fs.StringVar(&s.Restart, "restart", "", "")
+	fs.StringVar(&s.Hostname, "hostname", "", "")
+	fs.StringVarP(&s.Memory, "memory", "m", "", "")
+	fs.StringVar(&s.MemorySwap, "memory-swap", "", "")
+	fs.StringVar(&s.CPUs, "cpus", "", "")
+	fs.StringVar(&s.ShmSize, "shm-size", "", "")
+	fs.StringVarP(&s.User, "user", "u", "", "")
+	fs.StringVar(&s.Userns, "userns", "", "")
+	fs.StringVar(&s.Pull, "pull", "missing", "")
+
+	fs.Int64VarP(&s.CPUShares, "cpu-shares", "c", 0, "")
+	fs.Int64Var(&s.PidsLimit, "pids-limit", 0, "")
+
+	fs.BoolVar(&s.RM, "rm", false, "")
+	fs.BoolVar(&s.Privileged, "privileged", false, "")
+	fs.BoolVar(&s.ReadOnly, "read-only", false, "")
+	fs.BoolVarP(&s.Detach, "detach", "d", false, "")
+	fs.BoolVarP(&s.Interactive, "interactive", "i", false, "")
+	fs.BoolVarP(&s.TTY, "tty", "t", false, "")
+
+	fs.StringArrayVar(&s.DNS, "dns", nil, "")
+	fs.StringArrayVar(&s.DNSSearch, "dns-search", nil, "")
+	fs.StringArrayVar(&s.DNSOption, "dns-option", nil, "")
+	fs.StringArrayVarP(&s.Env, "env", "e", nil, "")
+	fs.StringArrayVar(&s.EnvFile, "env-file", nil, "")
+	fs.StringArrayVarP(&s.Volumes, "volume", "v", nil, "")
+	fs.StringArrayVar(&s.Networks, "network", nil, "")
+	fs.StringArrayVarP(&s.Ports, "publish", "p", nil, "")
+	fs.StringArrayVar(&s.Links, "link", nil, "")
+	fs.StringArrayVarP(&s.Labels, "label", "l", nil, "")
+	fs.StringArrayVar(&s.Ulimits, "ulimit", nil, "")
+	fs.StringArrayVar(&s.CapAdd, "cap-add", nil, "")
+	fs.StringArrayVar(&s.CapDrop, "cap-drop", nil, "")
+	fs.StringArrayVar(&s.SecOpts, "security-opt", nil, "")
+	fs.StringArrayVar(&s.Tmpfs, "tmpfs", nil, "")
+	fs.StringArrayVar(&s.GroupAdd, "group-add", nil, "")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupported, err)
+	}
+
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("%w: missing image", errUnsupported)
+	}
+
+	if s.Pull != "missing" && s.Pull != "never" {
+		return nil, fmt.Errorf("%w: pull policy %s", errUnsupported, s.Pull)
+	}
+
+	s.Image = fs.Arg(0)
+	s.Command = fs.Args()[1:]
+
+	return s, nil
+}
+
+// parseCreate reads the arguments of a `network create` or `volume create`.
+func parseCreate(args []string) (string, string, error) {
+	var driver string
+
+	fs := pflag.NewFlagSet("create", pflag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVarP(&driver, "driver", "d", "", "")
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		return "", "", errUnsupported
+	}
+
+	return fs.Arg(0), driver, nil
+}
+
+func (s *runSpec) config() (*containerConfig, error) {
+	c := &containerConfig{
+		Image:        s.Image,
+		Cmd:          s.Command,
+		WorkingDir:   s.WorkDir,
+		Hostname:     s.Hostname,
+		User:         s.User,
+		Tty:          s.TTY,
+		OpenStdin:    s.Interactive,
+		StdinOnce:    s.Interactive && !s.Detach,
+		AttachStdin:  s.Interactive && !s.Detach,
+		AttachStdout: !s.Detach,
+		AttachStderr: !s.Detach,
+		HostConfig: hostConfig{
+			AutoRemove:     s.RM && s.Detach,
+			Privileged:     s.Privileged,
+			CapAdd:         s.CapAdd,
+			CapDrop:        s.CapDrop,
+			SecurityOpt:    s.SecOpts,
+			ReadonlyRootfs: s.ReadOnly,
+			GroupAdd:       s.GroupAdd,
+			UsernsMode:     s.Userns,
+			DNS:            s.DNS,
+			DNSOptions:     s.DNSOption,
+			DNSSearch:      s.DNSSearch,
+		},
+	}
+
+	if s.Entrypoint != "" {
+		c.Entrypoint = []string{s.Entrypoint}
+	}
+
+	for _, f := range s.EnvFile {
+		env, err := readEnvFile(f)
+		if err != nil {
+			return nil, err
+		}
+		c.Env = append(c.Env, env...)
+	}
+	c.Env = append(c.Env, expandEnv(s.Env)...)
+
+	for _, v := range s.Volumes {
+		if strings.Contains(v, ":") {
+			c.HostConfig.Binds = append(c.HostConfig.Binds, v)
+			continue
+		}
+
+		if c.Volumes == nil {
+			c.Volumes = map[string]struct{}{}
+		}
+		c.Volumes[v] = struct{}{}
+	}
+
+	if len(s.Networks) > 0 {
+		c.HostConfig.NetworkMode = s.Networks[0]
+	}
+
+	for _, p := range s.Ports {
+		port, binding, err := parsePort(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = map[string]struct{}{}
+			c.HostConfig.PortBindings = map[string][]portBinding{}
+		}
+
+		c.ExposedPorts[port] = struct{}{}
+		c.HostConfig.PortBindings[port] = append(c.HostConfig.PortBindings[port], binding)
+	}
+
+	for _, l := range s.Links {
+		if !strings.Contains(l, ":") {
+			l = l + ":" + l
+		}
+		c.HostConfig.Links = append(c.HostConfig.Links, l)
+	}
+
+	for _, t := range s.Tmpfs {
+		if c.HostConfig.Tmpfs == nil {
+			c.HostConfig.Tmpfs = map[string]string{}
+		}
+
+		path, opts, _ := strings.Cut(t, ":")
+		c.HostConfig.Tmpfs[path] = opts
+	}
+
+	for _, l := range s.Labels {
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+
+		k, v, _ := strings.Cut(l, "=")
+		c.Labels[k] = v
+	}
+
+	err := s.limits(&c.HostConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Restart != "" {
+		name, count, _ := strings.Cut(s.Restart, ":")
+		c.HostConfig.RestartPolicy.Name = name
+
+		if count != "" {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return nil, fmt.Errorf("invalid restart policy %s", s.Restart)
+			}
+			c.HostConfig.RestartPolicy.MaximumRetryCount = n
+		}
+	}
+
+	return c, nil
+}
+
+func (s *runSpec) limits(h *hostConfig) error {
+	var err error
+
+	if s.Memory != "" {
+		h.Memory, err = units.ParseBytes(s.Memory)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch s.MemorySwap {
+	case "":
+	case "-1":
+		h.MemorySwap = -1
+	default:
+		h.MemorySwap, err = units.ParseBytes(s.MemorySwap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.ShmSize != "" {
+		h.ShmSize, err = units.ParseBytes(s.ShmSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.CPUs != "" {
+		cpus, err := strconv.ParseFloat(s.CPUs, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of cpus %s", s.CPUs)
+		}
+		h.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	h.CPUShares = s.CPUShares
+	h.PidsLimit = s.PidsLimit
+
+	for _, u := range s.Ulimits {
+		name, soft, hard, err := units.ParseUlimit(u)
+		if err != nil {
+			return err
+		}
+		h.Ulimits = append(h.Ulimits, ulimit{Name: name, Soft: soft, Hard: hard})
+	}
+
+	return nil
+}
+
+// parsePort translates the `[ip:][hostPort:]containerPort[/proto]` forms.
+// Port ranges are left to the fallback.
+func parsePort(p string) (string, portBinding, error) {
+	spec, proto, ok := strings.Cut(p, "/")
+	if !ok {
+		proto = "tcp"
+	}
+
+	if strings.Contains(spec, "-") {
+		return "", portBinding{}, fmt.Errorf("%w: port range %s", errUnsupported, p)
+	}
+
+	b := portBinding{}
+	parts := strings.Split(spec, ":")
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		b.HostPort = parts[0]
+	case 3:
+		b.HostIP, b.HostPort = parts[0], parts[1]
+	default:
+		return "", portBinding{}, fmt.Errorf("%w: port %s", errUnsupported, p)
+	}
+
+	return parts[len(parts)-1] + "/" + proto, b, nil
+}
+
+// expandEnv resolves `NAME` entries from the host environment, the same
+// way the docker client does, dropping the ones that are not set.
+func expandEnv(env []string) []string {
+	out := []string{}
+
+	for _, e := range env {
+		if strings.Contains(e, "=") {
+			out = append(out, e)
+			continue
+		}
+
+		if v, ok := os.LookupEnv(e); ok {
+			out = append(out, e+"="+v)
+		}
+	}
+
+	return out
+}
+
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	env := []string{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		env = append(env, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %v", path, err)
+	}
+
+	return expandEnv(env), nil
+}
@@ -0,0 +1,264 @@
+package runner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+type waitResponse struct {
+	StatusCode int `json:"StatusCode"`
+	Error      *struct {
+		Message string `json:"Message"`
+	} `json:"Error"`
+}
+
+// runContainer mirrors `docker run`: create, attach, start, wait and remove.
+func (e *Engine) runContainer(cmd Cmd, spec *runSpec) error {
+	stdin, stdout, stderr := e.Stdin, e.Stdout, e.Stderr
+	if cmd.Silent {
+		stdin, stdout, stderr = nil, io.Discard, io.Discard
+		spec.Interactive = false
+	}
+
+	config, err := spec.config()
+	if err != nil {
+		return err
+	}
+
+	id, err := e.createContainer(cmd.Args[0], spec, config, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range spec.Networks {
+		if i == 0 {
+			continue
+		}
+
+		err := e.call(http.MethodPost, "/networks/"+n+"/connect", nil, map[string]string{"Container": id}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to connect container to network %s: %v", n, err)
+		}
+	}
+
+	if spec.Detach {
+		err := e.call(http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, id)
+
+		return nil
+	}
+
+	if spec.RM {
+		defer e.call(http.MethodDelete, "/containers/"+id, url.Values{"v": {"1"}, "force": {"1"}}, nil, nil)
+	}
+
+	conn, br, err := e.hijack("/containers/"+id+"/attach", url.Values{
+		"stream": {"1"},
+		"stdin":  {strconv.Itoa(btoi(spec.Interactive))},
+		"stdout": {"1"},
+		"stderr": {"1"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %v", err)
+	}
+	defer conn.Close()
+
+	// the daemon answers the wait request before the container starts,
+	// so no exit can be missed between start and wait.
+	wait, err := e.do(http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"next-exit"}}, nil)
+	if err != nil {
+		return err
+	}
+	defer wait.Body.Close()
+
+	if spec.TTY {
+		if f, ok := stdin.(*os.File); ok && spec.Interactive && term.IsTerminal(int(f.Fd())) {
+			state, err := term.MakeRaw(int(f.Fd()))
+			if err == nil {
+				defer term.Restore(int(f.Fd()), state)
+			}
+		}
+	}
+
+	outw, errw := stdout, stderr
+	if len(cmd.Rewrites) > 0 && !cmd.Silent {
+		ow, ew := newRewriter(stdout, cmd.Rewrites), newRewriter(stderr, cmd.Rewrites)
+		defer ow.Flush()
+		defer ew.Flush()
+		outw, errw = ow, ew
+	}
+
+	output := make(chan error, 1)
+	go func() {
+		if spec.TTY {
+			_, err := io.Copy(outw, br)
+			output <- err
+			return
+		}
+		output <- demux(outw, errw, br)
+	}()
+
+	if spec.Interactive && stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	err = e.call(http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if spec.TTY {
+		stop := e.monitorSize(id, stdout)
+		defer stop()
+	}
+
+	// the container isn't in the terminal's process group, so it gets the
+	// terminal's signals from dockerbox too, including SIGCONT after a
+	// suspend
+	stop := forwardSignals(func(sig syscall.Signal) {
+		if sig == syscall.SIGWINCH {
+			return
+		}
+
+		e.call(http.MethodPost, "/containers/"+id+"/kill", url.Values{"signal": {strconv.Itoa(int(sig))}}, nil, nil)
+		suspend(sig)
+	}, processSignals, terminalSignals, []os.Signal{syscall.SIGCONT})
+	defer stop()
+
+	status := waitResponse{}
+	err = json.NewDecoder(wait.Body).Decode(&status)
+	if err != nil {
+		return fmt.Errorf("failed to wait for container: %v", err)
+	}
+
+	<-output
+
+	if status.Error != nil && status.Error.Message != "" {
+		return fmt.Errorf("failed to wait for container: %s", status.Error.Message)
+	}
+
+	if status.StatusCode != 0 {
+		return &ExitError{Code: status.StatusCode}
+	}
+
+	return nil
+}
+
+func (e *Engine) createContainer(exe string, spec *runSpec, config *containerConfig, stdout, stderr io.Writer) (string, error) {
+	query := url.Values{}
+	if spec.Name != "" {
+		query.Set("name", spec.Name)
+	}
+
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+
+	err := e.call(http.MethodPost, "/containers/create", query, config, &created)
+	if apiErr, ok := err.(*apiError); ok && apiErr.Status == http.StatusNotFound {
+		if spec.Pull == "never" {
+			return "", fmt.Errorf("image %s is not available locally and pulling is disabled", spec.Image)
+		}
+
+		fmt.Fprintf(stderr, "Unable to find image '%s' locally\n", spec.Image)
+
+		err = e.Fallback.Run(Cmd{Args: []string{exe, "pull", spec.Image}})
+		if err != nil {
+			return "", fmt.Errorf("failed to pull %s: %v", spec.Image, err)
+		}
+
+		err = e.call(http.MethodPost, "/containers/create", query, config, &created)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %v", err)
+	}
+
+	return created.ID, nil
+}
+
+// monitorSize keeps the container's pty in sync with the local terminal.
+func (e *Engine) monitorSize(id string, stdout io.Writer) func() {
+	f, ok := stdout.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return func() {}
+	}
+
+	resize := func() {
+		w, h, err := term.GetSize(int(f.Fd()))
+		if err != nil {
+			return
+		}
+
+		e.call(http.MethodPost, "/containers/"+id+"/resize", url.Values{
+			"h": {strconv.Itoa(h)},
+			"w": {strconv.Itoa(w)},
+		}, nil, nil)
+	}
+
+	resize()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+
+	go func() {
+		for range sigs {
+			resize()
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
+
+// demux splits the multiplexed stream of a container without a tty.
+func demux(stdout, stderr io.Writer, r io.Reader) error {
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+
+		_, err = io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:])))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
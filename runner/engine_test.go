@@ -0,0 +1,254 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEngine struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]map[string]any
+	status   int
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Body != nil {
+		body := map[string]any{}
+		if json.NewDecoder(r.Body).Decode(&body) == nil {
+			f.bodies[r.URL.Path] = body
+		}
+	}
+	f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1.41/containers/create":
+		json.NewEncoder(w).Encode(map[string]string{"Id": "abc"})
+	case "/v1.41/containers/abc/attach":
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Write(frame(1, "hello\n"))
+		rw.Write(frame(2, "oops\n"))
+		rw.Flush()
+	case "/v1.41/containers/abc/wait":
+		json.NewEncoder(w).Encode(map[string]int{"StatusCode": f.status})
+	case "/v1.41/networks/create", "/v1.41/volumes/create":
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "{}")
+	case "/v1.41/networks/net", "/v1.41/images/test:abc/json":
+		io.WriteString(w, `{"Name": "net"}`)
+	case "/v1.41/volumes/missing", "/v1.41/containers/missing/kill":
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such object: missing"}`)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func frame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+
+	return append(header, data...)
+}
+
+type recordingBackend struct {
+	cmds []Cmd
+}
+
+func (r *recordingBackend) Run(cmd Cmd) error {
+	r.cmds = append(r.cmds, cmd)
+	return nil
+}
+
+func newTestEngine(t *testing.T, status int) (*Engine, *fakeEngine, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeEngine{bodies: map[string]map[string]any{}, status: status}
+	srv := httptest.NewUnstartedServer(fake)
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	e, err := NewEngine("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	e.Stdin = &bytes.Buffer{}
+	e.Stdout = stdout
+	e.Stderr = stderr
+
+	return e, fake, stdout, stderr
+}
+
+func TestEngineRun(t *testing.T) {
+	e, fake, stdout, stderr := newTestEngine(t, 3)
+
+	err := e.Run(Cmd{Args: []string{
+		"docker", "run", "--name", "test", "--rm", "-e", "FOO=bar",
+		"-v", "/src:/src", "-p", "127.0.0.1:8080:80", "--network", "net",
+		"test:latest", "echo", "hi",
+	}})
+
+	assert.Equal(t, &ExitError{Code: 3}, err)
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Equal(t, "oops\n", stderr.String())
+	assert.Equal(t, []string{
+		"POST /v1.41/containers/create",
+		"POST /v1.41/containers/abc/attach",
+		"POST /v1.41/containers/abc/wait",
+		"POST /v1.41/containers/abc/start",
+		"DELETE /v1.41/containers/abc",
+	}, fake.requests)
+
+	create := fake.bodies["/v1.41/containers/create"]
+	assert.Equal(t, "test:latest", create["Image"])
+	assert.Equal(t, []any{"echo", "hi"}, create["Cmd"])
+	assert.Equal(t, []any{"FOO=bar"}, create["Env"])
+	assert.Equal(t, map[string]any{
+		"Binds":         []any{"/src:/src"},
+		"NetworkMode":   "net",
+		"PortBindings":  map[string]any{"80/tcp": []any{map[string]any{"HostIp": "127.0.0.1", "HostPort": "8080"}}},
+		"RestartPolicy": map[string]any{},
+		"AutoRemove":    false,
+		"Privileged":    false,
+	}, create["HostConfig"])
+}
+
+func TestRunSpecLimits(t *testing.T) {
+	spec, err := parseRun([]string{
+		"--memory", "512m", "--memory-swap", "-1", "--cpus", "1.5", "--cpu-shares", "512",
+		"--pids-limit", "100", "--shm-size", "1g", "--ulimit", "nofile=1024:2048", "test",
+	})
+	assert.Nil(t, err)
+
+	c, err := spec.config()
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(512<<20), c.HostConfig.Memory)
+	assert.Equal(t, int64(-1), c.HostConfig.MemorySwap)
+	assert.Equal(t, int64(1.5e9), c.HostConfig.NanoCPUs)
+	assert.Equal(t, int64(512), c.HostConfig.CPUShares)
+	assert.Equal(t, int64(100), c.HostConfig.PidsLimit)
+	assert.Equal(t, int64(1<<30), c.HostConfig.ShmSize)
+	assert.Equal(t, []ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, c.HostConfig.Ulimits)
+}
+
+func TestRunSpecSecurity(t *testing.T) {
+	spec, err := parseRun([]string{
+		"--read-only", "--cap-add", "NET_ADMIN", "--cap-drop", "ALL", "--security-opt", "no-new-privileges",
+		"--tmpfs", "/tmp", "--tmpfs", "/run:size=64m", "--network", "none", "test",
+	})
+	assert.Nil(t, err)
+
+	c, err := spec.config()
+	assert.Nil(t, err)
+
+	assert.True(t, c.HostConfig.ReadonlyRootfs)
+	assert.Equal(t, "none", c.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"NET_ADMIN"}, c.HostConfig.CapAdd)
+	assert.Equal(t, []string{"ALL"}, c.HostConfig.CapDrop)
+	assert.Equal(t, []string{"no-new-privileges"}, c.HostConfig.SecurityOpt)
+	assert.Equal(t, map[string]string{"/tmp": "", "/run": "size=64m"}, c.HostConfig.Tmpfs)
+}
+
+func TestEngineCreate(t *testing.T) {
+	e, fake, stdout, _ := newTestEngine(t, 0)
+
+	err := RunCmds(e, []Cmd{
+		{Args: []string{"docker", "network", "create", "--driver", "bridge", "net"}},
+		{Args: []string{"docker", "volume", "create", "vol"}},
+		{Silent: true, Args: []string{"docker", "kill", "missing"}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "vol\n", stdout.String())
+	assert.Equal(t, []string{
+		"POST /v1.41/networks/create",
+		"POST /v1.41/volumes/create",
+		"POST /v1.41/containers/missing/kill",
+	}, fake.requests)
+	assert.Equal(t, map[string]any{"Name": "net", "Driver": "bridge", "CheckDuplicate": true}, fake.bodies["/v1.41/networks/create"])
+}
+
+func TestEngineInspect(t *testing.T) {
+	e, fake, _, _ := newTestEngine(t, 0)
+
+	err := RunCmds(e, []Cmd{
+		{Args: []string{"docker", "network", "create", "net"}, Unless: []string{"docker", "network", "inspect", "net"}},
+		{Args: []string{"docker", "volume", "create", "missing"}, Unless: []string{"docker", "volume", "inspect", "missing"}},
+		{Args: []string{"docker", "build", "--tag", "test:abc", "."}, Unless: []string{"docker", "image", "inspect", "test:abc"}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"GET /v1.41/networks/net",
+		"GET /v1.41/volumes/missing",
+		"POST /v1.41/volumes/create",
+		"GET /v1.41/images/test:abc/json",
+	}, fake.requests)
+}
+
+func TestEngineKillError(t *testing.T) {
+	e, _, _, _ := newTestEngine(t, 0)
+
+	err := e.Run(Cmd{Args: []string{"docker", "kill", "missing"}})
+
+	assert.Equal(t, &apiError{Status: http.StatusNotFound, Message: "No such object: missing"}, err)
+}
+
+func TestEngineRuntime(t *testing.T) {
+	e, fake, _, _ := newTestEngine(t, 0)
+	fallback := &recordingBackend{}
+	e.Fallback = fallback
+
+	err := e.Run(Cmd{Args: []string{"podman", "run", "test"}})
+
+	assert.EqualError(t, err, "the engine backend only supports the docker runtime, not podman")
+	assert.Empty(t, fallback.cmds)
+	assert.Empty(t, fake.requests)
+}
+
+func TestEngineFallback(t *testing.T) {
+	e, fake, _, _ := newTestEngine(t, 0)
+	fallback := &recordingBackend{}
+	e.Fallback = fallback
+
+	cmds := []Cmd{
+		{Args: []string{"docker", "pull", "test"}},
+		{Args: []string{"docker", "run", "--unknown", "test"}},
+		{Args: []string{"docker", "run", "-p", "8000-8010:8000-8010", "test"}},
+	}
+
+	err := RunCmds(e, cmds)
+
+	assert.Nil(t, err)
+	assert.Equal(t, cmds, fallback.cmds)
+	assert.Empty(t, fake.requests)
+}
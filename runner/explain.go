@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Explain prints cmds as a shell script, each command preceded by a comment
+// naming where it came from.
+func Explain(w io.Writer, cmds []Cmd) error {
+	for _, cmd := range cmds {
+		comment := cmd.Source
+		if cmd.Silent {
+			comment += " (silent)"
+		}
+
+		line := cmd.String()
+		if len(cmd.Unless) > 0 {
+			unless := Cmd{Args: cmd.Unless}.String() + " >/dev/null 2>&1"
+			line = unless + " || " + line + " || " + unless
+		}
+
+		_, err := fmt.Fprintf(w, "# %s\n%s\n", comment, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String returns the command shell-quoted so it can be copy-pasted.
+func (c Cmd) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = quote(arg)
+	}
+
+	return strings.Join(args, " ")
+}
+
+func quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-+=/:.,@%", r))
+	}) == -1
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// output routes a child's stdout and stderr through rewriters. When they
+// are terminals the child gets a pty of its own instead of pipes, so it
+// still sees a terminal, can size itself and keeps colours.
+type output struct {
+	master *os.File
+	slave  *os.File
+	done   chan struct{}
+	flush  []*rewriter
+}
+
+func newOutput(c *exec.Cmd, rewrites []Rewrite) (*output, error) {
+	o := &output{}
+
+	if isTerminal(os.Stdout) || isTerminal(os.Stderr) {
+		master, slave, err := pty.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		// the container's own pty already translated line endings
+		term.MakeRaw(int(slave.Fd()))
+		pty.InheritSize(os.Stdout, master)
+
+		o.master, o.slave = master, slave
+	}
+
+	c.Stdout = o.writer(os.Stdout, rewrites)
+	c.Stderr = o.writer(os.Stderr, rewrites)
+
+	if o.master != nil {
+		w := newRewriter(os.Stdout, rewrites)
+		o.flush = append(o.flush, w)
+		o.done = make(chan struct{})
+
+		go func() {
+			defer close(o.done)
+			io.Copy(w, o.master)
+		}()
+	}
+
+	return o, nil
+}
+
+func (o *output) writer(f *os.File, rewrites []Rewrite) io.Writer {
+	if o.slave != nil && isTerminal(f) {
+		return o.slave
+	}
+
+	w := newRewriter(f, rewrites)
+	o.flush = append(o.flush, w)
+
+	return w
+}
+
+// started releases the parent's end of the pty once the child holds it,
+// so reading the master ends when the child exits.
+func (o *output) started() {
+	if o.slave != nil {
+		o.slave.Close()
+	}
+}
+
+// resize copies the terminal size onto the child's pty.
+func (o *output) resize() {
+	if o.master != nil {
+		pty.InheritSize(os.Stdout, o.master)
+	}
+}
+
+// close waits for the pty to drain and flushes whatever was held back.
+func (o *output) close() error {
+	if o.done != nil {
+		<-o.done
+		o.master.Close()
+	}
+
+	for _, w := range o.flush {
+		err := w.Flush()
+		if err != nil && !errors.Is(err, syscall.EPIPE) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rewrite replaces the path From with To wherever it appears as a whole
+// path prefix in a command's output.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// rewriteDelay bounds how long a trailing partial match is held back
+// waiting for the rest of the path, so prompts still show up promptly.
+const rewriteDelay = 20 * time.Millisecond
+
+// rewriter streams writes through to w, replacing rewritten paths. Bytes
+// that could be the start of a path are held back until the next write
+// or until rewriteDelay passes.
+type rewriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	rewrites []Rewrite
+	pending  []byte
+	timer    *time.Timer
+	// inPath is set while the output is in the middle of a path, where a
+	// match would be a suffix of a longer path rather than a mount path.
+	inPath bool
+	escape escapeState
+}
+
+type escapeState int
+
+const (
+	escapeNone escapeState = iota
+	escapeStart
+	escapeCSI
+)
+
+func newRewriter(w io.Writer, rewrites []Rewrite) *rewriter {
+	sorted := append([]Rewrite{}, rewrites...)
+	// the most specific path wins when mounts are nested
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].From) > len(sorted[j].From)
+	})
+
+	return &rewriter{w: w, rewrites: sorted}
+}
+
+func (r *rewriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+
+	err := r.write(append(r.pending, p...), false)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(r.pending) > 0 {
+		r.timer = time.AfterFunc(rewriteDelay, func() { r.Flush() })
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out anything held back.
+func (r *rewriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+
+	return r.write(r.pending, true)
+}
+
+func (r *rewriter) write(data []byte, final bool) error {
+	out := make([]byte, 0, len(data))
+	r.pending = nil
+
+	i := 0
+scan:
+	for i < len(data) {
+		if !r.inPath && r.escape == escapeNone && data[i] == '/' {
+			for _, rw := range r.rewrites {
+				rest := data[i:]
+				if !bytes.HasPrefix(rest, []byte(rw.From)) {
+					if !final && len(rest) < len(rw.From) && rw.From[:len(rest)] == string(rest) {
+						r.pending = append([]byte{}, rest...)
+						break scan
+					}
+					continue
+				}
+
+				end := i + len(rw.From)
+				if end == len(data) && !final {
+					r.pending = append([]byte{}, rest...)
+					break scan
+				}
+				if end < len(data) && isNameByte(data[end]) {
+					continue
+				}
+
+				out = append(out, rw.To...)
+				for ; i < end; i++ {
+					r.advance(data[i])
+				}
+				continue scan
+			}
+		}
+
+		out = append(out, data[i])
+		r.advance(data[i])
+		i++
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	_, err := r.w.Write(out)
+	return err
+}
+
+// advance tracks whether the next byte could start a path. Terminal
+// escape sequences are skipped over so coloured paths still match.
+func (r *rewriter) advance(b byte) {
+	switch r.escape {
+	case escapeStart:
+		r.escape = escapeNone
+		if b == '[' {
+			r.escape = escapeCSI
+		}
+		return
+	case escapeCSI:
+		if b >= 0x40 && b <= 0x7e {
+			r.escape = escapeNone
+		}
+		return
+	}
+
+	if b == 0x1b {
+		r.escape = escapeStart
+		r.inPath = false
+		return
+	}
+
+	r.inPath = isNameByte(b) || b == '/'
+}
+
+// isNameByte reports whether b can continue a path component.
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '.' || b == '_' || b == '-'
+}
@@ -1,29 +1,214 @@
 package runner
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/sethpollack/dockerbox/state"
+	"github.com/spf13/afero"
 )
 
 type Cmd struct {
 	Silent bool
+	// Main marks the run command of the invoked applet, as opposed to its
+	// hooks and the resources it depends on.
+	Main bool
+	// Source describes what the command was compiled from, e.g. "applet foo"
+	// or "before hook bar".
+	Source string
 	Args   []string
+	// Unless is run silently first, skipping the command if it succeeds.
+	// It's checked again when the command fails, so a concurrent run that
+	// got there first doesn't fail this one.
+	Unless []string
+	// Stamp is recorded once the command succeeds or is skipped.
+	Stamp *Stamp
+	// Rewrites are applied to the command's output, which keeps it from
+	// being exec'd.
+	Rewrites []Rewrite
+}
+
+// Stamp names a key in a state file recording when it was last touched.
+type Stamp struct {
+	File string
+	Key  string
+	// MaxAge skips the command while the stamp is younger than it.
+	MaxAge time.Duration
+}
+
+// Backend runs a single compiled command against a container runtime.
+type Backend interface {
+	Run(cmd Cmd) error
+}
+
+// Execer is implemented by backends that can replace the dockerbox process
+// with a command, leaving signals and the exit code to the runtime client.
+type Execer interface {
+	Exec(cmd Cmd) error
 }
 
-func RunCmds(cmds []Cmd) error {
-	for _, cmd := range cmds {
-		exec := exec.Command(cmd.Args[0], cmd.Args[1:]...)
-		if !cmd.Silent {
-			exec.Stdout = os.Stdout
-			exec.Stderr = os.Stderr
-			exec.Stdin = os.Stdin
+// ExitError reports a container that exited with a non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+func NewBackend(name, host string) (Backend, error) {
+	switch name {
+	case "", "cli":
+		return CLI{}, nil
+	case "engine":
+		return NewEngine(host)
+	default:
+		return nil, fmt.Errorf("unknown backend %s", name)
+	}
+}
+
+// RunCmds runs cmds in order. When the applet's own run command comes last
+// it replaces dockerbox if the backend supports it, otherwise every command
+// runs as a child with signals forwarded to it.
+func RunCmds(backend Backend, cmds []Cmd) error {
+	for i, cmd := range cmds {
+		if fresh(cmd) {
+			continue
+		}
+
+		if len(cmd.Unless) > 0 && backend.Run(Cmd{Silent: true, Source: cmd.Source, Args: cmd.Unless}) == nil {
+			err := stamp(cmd)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		err := exec.Run()
-		if err != nil && !cmd.Silent {
+		if ex, ok := backend.(Execer); ok && cmd.Main && i == len(cmds)-1 && len(cmd.Rewrites) == 0 {
+			return ex.Exec(cmd)
+		}
+
+		err := backend.Run(cmd)
+		if err != nil && len(cmd.Unless) > 0 && backend.Run(Cmd{Silent: true, Source: cmd.Source, Args: cmd.Unless}) == nil {
+			err = nil
+		}
+		if err != nil {
+			if cmd.Silent {
+				continue
+			}
+			if !cmd.Main {
+				// keep the exit code while saying which dependency failed
+				return fmt.Errorf("failed to run %s: %w", cmd.Source, err)
+			}
+			return err
+		}
+
+		err = stamp(cmd)
+		if err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// fresh reports whether the command's stamp is recent enough to skip it.
+// Unreadable state is treated as stale.
+func fresh(cmd Cmd) bool {
+	if cmd.Stamp == nil || cmd.Stamp.MaxAge == 0 {
+		return false
+	}
+
+	stamps, err := state.Load(afero.NewOsFs(), cmd.Stamp.File)
+	if err != nil {
+		return false
+	}
+
+	last, ok := stamps[cmd.Stamp.Key]
+	return ok && time.Since(last) < cmd.Stamp.MaxAge
+}
+
+func stamp(cmd Cmd) error {
+	if cmd.Stamp == nil {
+		return nil
+	}
+
+	err := state.Touch(afero.NewOsFs(), cmd.Stamp.File, cmd.Stamp.Key)
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %v", cmd.Stamp.Key, err)
+	}
+
+	return nil
+}
+
+// CLI runs commands by executing the container runtime's client binary.
+type CLI struct{}
+
+func (CLI) Run(cmd Cmd) (err error) {
+	exec := exec.Command(cmd.Args[0], cmd.Args[1:]...)
+	var out *output
+	if !cmd.Silent {
+		exec.Stdout = os.Stdout
+		exec.Stderr = os.Stderr
+		exec.Stdin = os.Stdin
+
+		if len(cmd.Rewrites) > 0 {
+			out, err = newOutput(exec, cmd.Rewrites)
+			if err != nil {
+				return fmt.Errorf("failed to rewrite output: %v", err)
+			}
+			defer func() {
+				cerr := out.close()
+				if err == nil {
+					err = cerr
+				}
+			}()
+		}
+	}
+
+	err = exec.Start()
+	if out != nil {
+		out.started()
+	}
+	if err != nil {
+		return err
+	}
+
+	// the child shares dockerbox's process group, so the terminal's signals
+	// reach it without being forwarded; dockerbox only has to survive them
+	stop := forwardSignals(func(sig syscall.Signal) {
+		switch sig {
+		case syscall.SIGWINCH:
+			// the child's own pty has to be resized before it looks
+			if out != nil {
+				out.resize()
+				exec.Process.Signal(sig)
+			}
+		case syscall.SIGINT, syscall.SIGQUIT:
+			// already sent to the child by the terminal
+		case syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+			suspend(sig)
+		default:
+			exec.Process.Signal(sig)
+		}
+	}, processSignals, terminalSignals)
+	defer stop()
+
+	return exec.Wait()
+}
+
+func (CLI) Exec(cmd Cmd) error {
+	path, err := exec.LookPath(cmd.Args[0])
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(path, cmd.Args, os.Environ())
+}
@@ -0,0 +1,264 @@
+package runner
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethpollack/dockerbox/state"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+type execBackend struct {
+	recordingBackend
+	execed []Cmd
+}
+
+func (e *execBackend) Exec(cmd Cmd) error {
+	e.execed = append(e.execed, cmd)
+	return nil
+}
+
+type failingBackend struct {
+	recordingBackend
+}
+
+func (f *failingBackend) Run(cmd Cmd) error {
+	f.recordingBackend.Run(cmd)
+	return errors.New("failed")
+}
+
+func TestRunCmds(t *testing.T) {
+	tt := []struct {
+		name   string
+		cmds   []Cmd
+		run    []Cmd
+		execed []Cmd
+	}{
+		{
+			name: "execs the applet when it runs last",
+			cmds: []Cmd{
+				{Args: []string{"docker", "run", "before"}},
+				{Main: true, Args: []string{"docker", "run", "test"}},
+			},
+			run: []Cmd{
+				{Args: []string{"docker", "run", "before"}},
+			},
+			execed: []Cmd{
+				{Main: true, Args: []string{"docker", "run", "test"}},
+			},
+		},
+		{
+			name: "runs the applet as a child when after hooks follow",
+			cmds: []Cmd{
+				{Main: true, Args: []string{"docker", "run", "test"}},
+				{Args: []string{"docker", "run", "after"}},
+			},
+			run: []Cmd{
+				{Main: true, Args: []string{"docker", "run", "test"}},
+				{Args: []string{"docker", "run", "after"}},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &execBackend{}
+
+			err := RunCmds(backend, tc.cmds)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.run, backend.cmds)
+			assert.Equal(t, tc.execed, backend.execed)
+		})
+	}
+}
+
+func TestRunCmdsSilent(t *testing.T) {
+	backend := &failingBackend{}
+
+	err := RunCmds(backend, []Cmd{
+		{Silent: true, Args: []string{"docker", "kill", "test"}},
+		{Main: true, Args: []string{"docker", "run", "test"}},
+		{Args: []string{"docker", "run", "after"}},
+	})
+
+	assert.Equal(t, errors.New("failed"), err)
+	assert.Len(t, backend.cmds, 2)
+}
+
+func TestRunCmdsDependencyError(t *testing.T) {
+	backend := &failingBackend{}
+
+	err := RunCmds(backend, []Cmd{
+		{Source: "network test", Args: []string{"docker", "network", "create", "test"}},
+		{Main: true, Args: []string{"docker", "run", "test"}},
+	})
+
+	assert.EqualError(t, err, "failed to run network test: failed")
+	assert.Len(t, backend.cmds, 1)
+}
+
+func TestExplain(t *testing.T) {
+	out := &strings.Builder{}
+
+	err := Explain(out, []Cmd{
+		{Silent: true, Source: "applet test", Args: []string{"docker", "kill", "test"}},
+		{Main: true, Source: "applet test", Args: []string{"docker", "run", "-e", "FOO=a b", "test", "echo", "it's", ""}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `# applet test (silent)
+docker kill test
+# applet test
+docker run -e 'FOO=a b' test echo 'it'\''s' ''
+`, out.String())
+}
+
+func TestRunCmdsUnless(t *testing.T) {
+	backend := &recordingBackend{}
+	file := filepath.Join(t.TempDir(), "state.json")
+
+	err := RunCmds(backend, []Cmd{
+		{Args: []string{"docker", "run", "-d", "test"}, Unless: []string{"docker", "start", "test"}, Stamp: &Stamp{File: file, Key: "test"}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Cmd{{Silent: true, Args: []string{"docker", "start", "test"}}}, backend.cmds)
+
+	stamps, err := state.Load(afero.NewOsFs(), file)
+	assert.Nil(t, err)
+	assert.Contains(t, stamps, "test")
+}
+
+type racingBackend struct {
+	recordingBackend
+}
+
+// Run fails the first two commands, as if another run created the container
+// between checking for it and creating it.
+func (r *racingBackend) Run(cmd Cmd) error {
+	r.recordingBackend.Run(cmd)
+	if len(r.cmds) <= 2 {
+		return errors.New("failed")
+	}
+
+	return nil
+}
+
+func TestRunCmdsUnlessRace(t *testing.T) {
+	backend := &racingBackend{}
+	file := filepath.Join(t.TempDir(), "state.json")
+
+	err := RunCmds(backend, []Cmd{
+		{Args: []string{"docker", "run", "-d", "test"}, Unless: []string{"docker", "start", "test"}, Stamp: &Stamp{File: file, Key: "test"}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Cmd{
+		{Silent: true, Args: []string{"docker", "start", "test"}},
+		{Args: []string{"docker", "run", "-d", "test"}, Unless: []string{"docker", "start", "test"}, Stamp: &Stamp{File: file, Key: "test"}},
+		{Silent: true, Args: []string{"docker", "start", "test"}},
+	}, backend.cmds)
+
+	stamps, err := state.Load(afero.NewOsFs(), file)
+	assert.Nil(t, err)
+	assert.Contains(t, stamps, "test")
+}
+
+func TestRunCmdsFresh(t *testing.T) {
+	backend := &recordingBackend{}
+	file := filepath.Join(t.TempDir(), "pulls.json")
+
+	err := state.Touch(afero.NewOsFs(), file, "fresh")
+	assert.Nil(t, err)
+
+	err = RunCmds(backend, []Cmd{
+		{Args: []string{"docker", "pull", "fresh"}, Stamp: &Stamp{File: file, Key: "fresh", MaxAge: time.Hour}},
+		{Args: []string{"docker", "pull", "stale"}, Stamp: &Stamp{File: file, Key: "stale", MaxAge: time.Hour}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Cmd{{Args: []string{"docker", "pull", "stale"}, Stamp: &Stamp{File: file, Key: "stale", MaxAge: time.Hour}}}, backend.cmds)
+
+	stamps, err := state.Load(afero.NewOsFs(), file)
+	assert.Nil(t, err)
+	assert.Contains(t, stamps, "stale")
+}
+
+func TestRewriter(t *testing.T) {
+	tt := []struct {
+		name   string
+		writes []string
+		out    string
+	}{
+		{
+			name:   "rewrites mount paths",
+			writes: []string{"/src/app/user.rb:12: error\n"},
+			out:    "/home/me/project/app/user.rb:12: error\n",
+		},
+		{
+			name:   "rewrites the mount itself",
+			writes: []string{"cd /src\n", "in /src"},
+			out:    "cd /home/me/project\nin /home/me/project",
+		},
+		{
+			name:   "prefers the most specific mount",
+			writes: []string{"/src/vendor/gem.rb /src/app.rb"},
+			out:    "/opt/vendor/gem.rb /home/me/project/app.rb",
+		},
+		{
+			name:   "rewrites paths split across writes",
+			writes: []string{"error in /s", "rc/app", "/user.rb\n", "(/src", "/app.rb)\n"},
+			out:    "error in /home/me/project/app/user.rb\n(/home/me/project/app.rb)\n",
+		},
+		{
+			name:   "leaves other paths alone",
+			writes: []string{"/srcs/a /opt/src/a src/a /sr\n"},
+			out:    "/srcs/a /opt/src/a src/a /sr\n",
+		},
+		{
+			name:   "flushes a held back prefix",
+			writes: []string{"prompt /s"},
+			out:    "prompt /s",
+		},
+		{
+			name:   "keeps other bytes intact",
+			writes: []string{"\x1b[31m/src/a.rb\x1b[0m\r\n"},
+			out:    "\x1b[31m/home/me/project/a.rb\x1b[0m\r\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &strings.Builder{}
+			w := newRewriter(out, []Rewrite{
+				{From: "/src", To: "/home/me/project"},
+				{From: "/src/vendor", To: "/opt/vendor"},
+			})
+
+			for _, s := range tc.writes {
+				n, err := w.Write([]byte(s))
+				assert.Nil(t, err)
+				assert.Equal(t, len(s), n)
+			}
+
+			assert.Nil(t, w.Flush())
+			assert.Equal(t, tc.out, out.String())
+		})
+	}
+}
+
+func TestRunCmdsRewrites(t *testing.T) {
+	backend := &execBackend{}
+	cmd := Cmd{Main: true, Args: []string{"docker", "run", "test"}, Rewrites: []Rewrite{{From: "/src", To: "/home/me/project"}}}
+
+	err := RunCmds(backend, []Cmd{cmd})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Cmd{cmd}, backend.cmds)
+	assert.Nil(t, backend.execed)
+}
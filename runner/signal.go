@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	// processSignals are sent to dockerbox itself, e.g. by kill or a
+	// supervisor, so its child only gets them when they are forwarded.
+	processSignals = []os.Signal{syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+	// terminalSignals are sent to the terminal's foreground process group,
+	// which a child process is part of along with dockerbox.
+	terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGWINCH}
+)
+
+// forwardSignals relays sigs to send until the returned func is called.
+func forwardSignals(send func(syscall.Signal), sigs ...[]os.Signal) func() {
+	ch := make(chan os.Signal, 8)
+	for _, s := range sigs {
+		signal.Notify(ch, s...)
+	}
+
+	go func() {
+		for sig := range ch {
+			if s, ok := sig.(syscall.Signal); ok {
+				send(s)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+// suspend stops dockerbox on job control signals, so the shell gets the
+// terminal back along with the suspended applet.
+func suspend(sig syscall.Signal) {
+	switch sig {
+	case syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+		syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	}
+}
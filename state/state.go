@@ -0,0 +1,79 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Load reads the timestamps recorded in path. A missing file has none.
+func Load(fs afero.Fs, path string) (map[string]time.Time, error) {
+	stamps := map[string]time.Time{}
+
+	bytes, err := afero.ReadFile(fs, path)
+	if os.IsNotExist(err) {
+		return stamps, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	err = json.Unmarshal(bytes, &stamps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return stamps, nil
+}
+
+// Touch records the current time for keys in path.
+func Touch(fs afero.Fs, path string, keys ...string) error {
+	return update(fs, path, func(stamps map[string]time.Time) {
+		now := time.Now()
+		for _, k := range keys {
+			stamps[k] = now
+		}
+	})
+}
+
+// Delete forgets keys in path.
+func Delete(fs afero.Fs, path string, keys ...string) error {
+	return update(fs, path, func(stamps map[string]time.Time) {
+		for _, k := range keys {
+			delete(stamps, k)
+		}
+	})
+}
+
+func update(fs afero.Fs, path string, fn func(map[string]time.Time)) error {
+	stamps, err := Load(fs, path)
+	if err != nil {
+		return err
+	}
+
+	fn(stamps)
+
+	bytes, err := json.MarshalIndent(stamps, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+
+	err = fs.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+
+	// write and rename so concurrent invocations never read a partial file
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+
+	err = afero.WriteFile(fs, tmp, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	return fs.Rename(tmp, path)
+}
@@ -0,0 +1,31 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "/root/.dockerbox/state/test.json"
+
+	stamps, err := Load(fs, path)
+	assert.Nil(t, err)
+	assert.Empty(t, stamps)
+
+	before := time.Now()
+
+	err = Touch(fs, path, "a", "b")
+	assert.Nil(t, err)
+
+	err = Delete(fs, path, "b")
+	assert.Nil(t, err)
+
+	stamps, err = Load(fs, path)
+	assert.Nil(t, err)
+	assert.Len(t, stamps, 1)
+	assert.False(t, stamps["a"].Before(before))
+}
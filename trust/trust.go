@@ -0,0 +1,169 @@
+// Package trust records which config files outside the root dir the user
+// allowed, by path and content hash, so a cloned repo can't silently change
+// how applets run.
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+)
+
+// Status is the trust of a single config file.
+type Status string
+
+const (
+	Trusted Status = "trusted"
+	// Untrusted files were never allowed, or were denied.
+	Untrusted Status = "untrusted"
+	// Changed files were allowed, but their contents changed since.
+	Changed Status = "changed"
+	Missing Status = "missing"
+)
+
+type Store struct {
+	// Allowed maps the absolute path of allowed files to the sha256 of
+	// their contents.
+	Allowed map[string]string `json:"allowed"`
+}
+
+func Path(rootDir string) string {
+	return filepath.Join(rootDir, "state", "trust.json")
+}
+
+// Load reads the store at path. A missing file trusts nothing.
+func Load(fs afero.Fs, path string) (*Store, error) {
+	s := &Store{Allowed: map[string]string{}}
+
+	bytes, err := afero.ReadFile(fs, path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	err = json.Unmarshal(bytes, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if s.Allowed == nil {
+		s.Allowed = map[string]string{}
+	}
+
+	return s, nil
+}
+
+func (s *Store) Write(fs afero.Fs, path string) error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trust store: %v", err)
+	}
+
+	err = fs.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+
+	err = afero.WriteFile(fs, tmp, append(bytes, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	err = fs.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Allow trusts the current contents of file.
+func (s *Store) Allow(fs afero.Fs, file string) error {
+	sum, err := hash(fs, file)
+	if err != nil {
+		return err
+	}
+
+	s.Allowed[file] = sum
+
+	return nil
+}
+
+// Deny forgets file, reporting whether it was allowed.
+func (s *Store) Deny(file string) bool {
+	_, ok := s.Allowed[file]
+	delete(s.Allowed, file)
+
+	return ok
+}
+
+func (s *Store) Status(fs afero.Fs, file string) Status {
+	allowed, ok := s.Allowed[file]
+
+	sum, err := hash(fs, file)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		return Missing
+	case !ok || err != nil:
+		return Untrusted
+	case sum != allowed:
+		return Changed
+	default:
+		return Trusted
+	}
+}
+
+// Trusts returns a func reporting whether a file is trusted, e.g. for
+// dockerbox.GetConfigurations.
+func (s *Store) Trusts(fs afero.Fs) func(string) bool {
+	return func(file string) bool {
+		return s.Status(fs, file) == Trusted
+	}
+}
+
+// Files returns the allowed files sorted by path.
+func (s *Store) Files() []string {
+	files := make([]string, 0, len(s.Allowed))
+	for f := range s.Allowed {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// Filter splits sources into the ones that can be loaded and the project
+// configs that aren't trusted. Configs found anywhere but in the project
+// are set up by the user and always loaded.
+func (s *Store) Filter(fs afero.Fs, sources []dockerbox.Source) ([]dockerbox.Source, []dockerbox.Source) {
+	loaded, untrusted := []dockerbox.Source{}, []dockerbox.Source{}
+
+	for _, src := range sources {
+		if src.Origin != dockerbox.Project || s.Status(fs, src.Path) == Trusted {
+			loaded = append(loaded, src)
+		} else {
+			untrusted = append(untrusted, src)
+		}
+	}
+
+	return loaded, untrusted
+}
+
+func hash(fs afero.Fs, file string) (string, error) {
+	bytes, err := afero.ReadFile(fs, file)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(bytes)), nil
+}
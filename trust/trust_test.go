@@ -0,0 +1,66 @@
+package trust
+
+import (
+	"testing"
+
+	"github.com/sethpollack/dockerbox/dockerbox"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := Path("/root/.dockerbox")
+
+	files := map[string]string{
+		"/root/.dockerbox/tools.dbx.cue": `applets: {}`,
+		"/src/app/app.dbx.cue":           `applets: {}`,
+		"/src/src.dbx.cue":               `applets: {}`,
+	}
+	for f, data := range files {
+		err := afero.WriteFile(fs, f, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := Load(fs, path)
+	assert.Nil(t, err)
+	assert.Equal(t, Untrusted, s.Status(fs, "/src/app/app.dbx.cue"))
+
+	err = s.Allow(fs, "/src/app/app.dbx.cue")
+	assert.Nil(t, err)
+	err = s.Write(fs, path)
+	assert.Nil(t, err)
+
+	s, err = Load(fs, path)
+	assert.Nil(t, err)
+	assert.Equal(t, Trusted, s.Status(fs, "/src/app/app.dbx.cue"))
+
+	loaded, untrusted := s.Filter(fs, []dockerbox.Source{
+		{Path: "/root/.dockerbox/tools.dbx.cue", Origin: dockerbox.Root},
+		{Path: "/src/src.dbx.cue", Origin: dockerbox.Project},
+		{Path: "/src/app/app.dbx.cue", Origin: dockerbox.Project},
+	})
+	assert.Equal(t, []dockerbox.Source{
+		{Path: "/root/.dockerbox/tools.dbx.cue", Origin: dockerbox.Root},
+		{Path: "/src/app/app.dbx.cue", Origin: dockerbox.Project},
+	}, loaded)
+	assert.Equal(t, []dockerbox.Source{{Path: "/src/src.dbx.cue", Origin: dockerbox.Project}}, untrusted)
+
+	err = afero.WriteFile(fs, "/src/app/app.dbx.cue", []byte(`applets: test: privileged: true`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Changed, s.Status(fs, "/src/app/app.dbx.cue"))
+
+	err = fs.Remove("/src/app/app.dbx.cue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Missing, s.Status(fs, "/src/app/app.dbx.cue"))
+
+	assert.True(t, s.Deny("/src/app/app.dbx.cue"))
+	assert.False(t, s.Deny("/src/app/app.dbx.cue"))
+	assert.Empty(t, s.Files())
+}
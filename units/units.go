@@ -0,0 +1,65 @@
+package units
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?) ?([kKmMgGtTpP])?[iI]?[bB]?$`)
+
+var multipliers = map[string]float64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+	"p": 1 << 50,
+}
+
+// ParseBytes reads a size such as 512m or 1.5GB in binary units, the same
+// way docker's memory and shm-size flags do.
+func ParseBytes(size string) (int64, error) {
+	m := sizeRegexp.FindStringSubmatch(size)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	return int64(n * multipliers[strings.ToLower(m[2])]), nil
+}
+
+// ParseUlimit reads a ulimit in docker's `name=soft[:hard]` form. The hard
+// limit defaults to the soft one.
+func ParseUlimit(ulimit string) (string, int64, int64, error) {
+	name, limits, ok := strings.Cut(ulimit, "=")
+	if !ok || name == "" {
+		return "", 0, 0, fmt.Errorf("invalid ulimit %q", ulimit)
+	}
+
+	soft, hard, ok := strings.Cut(limits, ":")
+	if !ok {
+		hard = soft
+	}
+
+	s, err := strconv.ParseInt(soft, 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid ulimit %q", ulimit)
+	}
+
+	h, err := strconv.ParseInt(hard, 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid ulimit %q", ulimit)
+	}
+
+	if s > h {
+		return "", 0, 0, fmt.Errorf("invalid ulimit %q: soft limit exceeds hard limit", ulimit)
+	}
+
+	return name, s, h, nil
+}
@@ -0,0 +1,60 @@
+package units
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	tt := []struct {
+		size  string
+		bytes int64
+		err   error
+	}{
+		{size: "1024", bytes: 1024},
+		{size: "512m", bytes: 512 << 20},
+		{size: "1.5g", bytes: 3 << 29},
+		{size: "2GB", bytes: 2 << 30},
+		{size: "64MiB", bytes: 64 << 20},
+		{size: "lots", err: errors.New(`invalid size "lots"`)},
+		{size: "-1", err: errors.New(`invalid size "-1"`)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.size, func(t *testing.T) {
+			bytes, err := ParseBytes(tc.size)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.bytes, bytes)
+		})
+	}
+}
+
+func TestParseUlimit(t *testing.T) {
+	tt := []struct {
+		ulimit string
+		name   string
+		soft   int64
+		hard   int64
+		err    error
+	}{
+		{ulimit: "nofile=1024:2048", name: "nofile", soft: 1024, hard: 2048},
+		{ulimit: "nproc=512", name: "nproc", soft: 512, hard: 512},
+		{ulimit: "nofile", err: errors.New(`invalid ulimit "nofile"`)},
+		{ulimit: "nofile=a:b", err: errors.New(`invalid ulimit "nofile=a:b"`)},
+		{ulimit: "nofile=2048:1024", err: errors.New(`invalid ulimit "nofile=2048:1024": soft limit exceeds hard limit`)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.ulimit, func(t *testing.T) {
+			name, soft, hard, err := ParseUlimit(tc.ulimit)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.name, name)
+			assert.Equal(t, tc.soft, soft)
+			assert.Equal(t, tc.hard, hard)
+		})
+	}
+}